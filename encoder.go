@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+var videoCodecs = []Codec{CodecH264, CodecH265, CodecVP8, CodecVP9, CodecAV1}
+
+var linuxH264Modes = []LinuxH264Mode{
+	LinuxH264VAAPI, LinuxH264RaspiV4L2, LinuxH264Libcamera, LinuxH264CameraH264, LinuxH264X264, LinuxH264OpenH264,
+}
+
+var linuxH265Modes = []LinuxH265Mode{LinuxH265VAAPI, LinuxH265X265}
+
+// encoderChain returns the encoders that can produce codec on the given
+// platform, best first. The builders know how to feed each of them.
+func encoderChain(platform string, variant LinuxVariant, codec Codec, linuxH264Mode LinuxH264Mode, linuxH265Mode LinuxH265Mode) []string {
+	if platform != "linux" {
+		switch codec {
+		case CodecH264:
+			return []string{"vtenc_h264_hw", "vtenc_h264", "x264enc"}
+		case CodecH265:
+			return []string{"vtenc_h265_hw", "vtenc_h265", "x265enc"}
+		case CodecVP8:
+			return []string{"vp8enc"}
+		case CodecVP9:
+			return []string{"vp9enc"}
+		default:
+			return []string{"svtav1enc", "rav1enc", "av1enc"}
+		}
+	}
+	switch variant {
+	case LinuxJetson:
+		switch codec {
+		case CodecH264:
+			return []string{"nvv4l2h264enc"}
+		case CodecH265:
+			return []string{"nvv4l2h265enc"}
+		case CodecVP8:
+			return []string{"nvv4l2vp8enc"}
+		case CodecVP9:
+			return []string{"nvv4l2vp9enc"}
+		default:
+			return nil
+		}
+	case LinuxRock5:
+		switch codec {
+		case CodecH264:
+			return []string{"mpph264enc"}
+		case CodecH265:
+			return []string{"mpph265enc"}
+		case CodecVP8:
+			return []string{"mppvp8enc"}
+		}
+	}
+	switch codec {
+	case CodecH264:
+		switch linuxH264Mode {
+		case LinuxH264RaspiV4L2, LinuxH264Libcamera:
+			return []string{"v4l2h264enc"}
+		case LinuxH264CameraH264:
+			return []string{"h264parse"}
+		case LinuxH264X264:
+			return []string{"x264enc"}
+		case LinuxH264OpenH264:
+			return []string{"openh264enc"}
+		default:
+			return []string{"vaapih264enc", "vah264enc", "x264enc", "openh264enc"}
+		}
+	case CodecH265:
+		if linuxH265Mode == LinuxH265X265 {
+			return []string{"x265enc"}
+		}
+		return []string{"vaapih265enc", "vah265enc", "x265enc"}
+	case CodecVP8:
+		return []string{"vp8enc"}
+	case CodecVP9:
+		return []string{"vp9enc"}
+	default:
+		return []string{"svtav1enc", "rav1enc", "av1enc"}
+	}
+}
+
+// probeEncoder returns the first encoder in the chain that is present in the
+// GStreamer registry, or "" if none is.
+func probeEncoder(platform string, variant LinuxVariant, codec Codec, linuxH264Mode LinuxH264Mode, linuxH265Mode LinuxH265Mode) string {
+	for _, name := range encoderChain(platform, variant, codec, linuxH264Mode, linuxH265Mode) {
+		if gst.Find(name) != nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// probeCodec returns the encoders available for codec, one for each Linux
+// H264/H265 mode that has one, when that mode is chosen after the codec.
+// Elsewhere it returns probeEncoder's single choice.
+func probeCodec(platform string, variant LinuxVariant, codec Codec) []string {
+	var found []string
+	add := func(encoder string) {
+		if encoder != "" && !slices.Contains(found, encoder) {
+			found = append(found, encoder)
+		}
+	}
+	switch {
+	case platform != "linux" || variant == LinuxJetson || variant == LinuxRock5:
+		add(probeEncoder(platform, variant, codec, LinuxH264VAAPI, LinuxH265VAAPI))
+	case codec == CodecH264:
+		for _, m := range linuxH264Modes {
+			add(probeEncoder(platform, variant, codec, m, LinuxH265VAAPI))
+		}
+	case codec == CodecH265:
+		for _, m := range linuxH265Modes {
+			add(probeEncoder(platform, variant, codec, LinuxH264VAAPI, m))
+		}
+	default:
+		add(probeEncoder(platform, variant, codec, LinuxH264VAAPI, LinuxH265VAAPI))
+	}
+	return found
+}
+
+// selectEncoder returns the encoder to use for the chosen codec and modes.
+func selectEncoder(platform string, variant LinuxVariant, codec Codec, linuxH264Mode LinuxH264Mode, linuxH265Mode LinuxH265Mode) (string, error) {
+	if encoder := probeEncoder(platform, variant, codec, linuxH264Mode, linuxH265Mode); encoder != "" {
+		return encoder, nil
+	}
+	chain := encoderChain(platform, variant, codec, linuxH264Mode, linuxH265Mode)
+	if len(chain) == 0 {
+		return "", fmt.Errorf("%s is not supported on %s/%s", codec, platform, variant)
+	}
+	return "", fmt.Errorf("no %s encoder available (tried %s)", codec, strings.Join(chain, ", "))
+}
+
+// encoderProps returns the low-latency settings for the software and
+// fallback encoders.
+func encoderProps(encoder string) []Property {
+	switch encoder {
+	case "x264enc", "x265enc":
+		return []Property{prop("tune", "zerolatency"), prop("speed-preset", "ultrafast")}
+	case "openh264enc":
+		return []Property{prop("usage-type", "camera"), prop("complexity", "low")}
+	case "rav1enc":
+		return []Property{prop("speed-preset", 10), prop("low-latency", true)}
+	case "av1enc":
+		return []Property{prop("usage-profile", "realtime"), prop("cpu-used", 8)}
+	default:
+		return nil
+	}
+}
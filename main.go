@@ -1,19 +1,24 @@
 // This is a simplified go-reimplementation of the gst-launch-<version> cli tool.
-// It builds a pipeline from interactive prompts instead of CLI pipeline strings.
+// It builds a pipeline from flags and interactive prompts instead of CLI pipeline strings.
+// Any value not supplied as a flag is asked for on stdin.
+// "cli receive" runs the matching receiver for testing the other end, and
+// "cli selftest" loops every available codec back through both, and
+// "cli serve-rtsp" serves the stream to RTSP clients instead of sending it.
 package main
 
 import (
 	"bufio"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/go-gst/go-glib/glib"
-	"github.com/go-gst/go-gst/examples"
 	"github.com/go-gst/go-gst/gst"
 )
 
@@ -24,6 +29,10 @@ type Mode struct {
 	Framerate string
 }
 
+// mjpegFormat is the Format of a mode captured as image/jpeg, which the
+// source chain decodes with jpegdec.
+const mjpegFormat = "MJPEG"
+
 type Codec string
 
 const (
@@ -39,6 +48,8 @@ type AudioCodec string
 const (
 	AudioOpus AudioCodec = "OPUS"
 	AudioPCMU AudioCodec = "PCMU"
+	// AudioAAC is only sent over RTMP, whose builder appends the encoder.
+	AudioAAC AudioCodec = "AAC"
 )
 
 type LinuxH264Mode string
@@ -48,6 +59,15 @@ const (
 	LinuxH264RaspiV4L2  LinuxH264Mode = "raspi-v4l2"
 	LinuxH264Libcamera  LinuxH264Mode = "libcamera"
 	LinuxH264CameraH264 LinuxH264Mode = "camera-h264"
+	LinuxH264X264       LinuxH264Mode = "x264"
+	LinuxH264OpenH264   LinuxH264Mode = "openh264"
+)
+
+type LinuxH265Mode string
+
+const (
+	LinuxH265VAAPI LinuxH265Mode = "vaapi"
+	LinuxH265X265  LinuxH265Mode = "x265"
 )
 
 type LinuxVariant string
@@ -60,12 +80,16 @@ const (
 )
 
 func (m Mode) String() string {
-	return fmt.Sprintf("%dx%d %s %s", m.Width, m.Height, m.Framerate, m.Format)
+	return strings.TrimSpace(fmt.Sprintf("%dx%d %s %s", m.Width, m.Height, m.Framerate, m.Format))
 }
 
-func runPipeline(mainLoop *glib.MainLoop) error {
-	if len(os.Args) > 1 {
-		fmt.Fprintln(os.Stderr, "ignoring CLI arguments; this tool is interactive")
+func runPipeline(mainLoop *glib.MainLoop, args []string) error {
+	opts, err := parseOptions(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
 	}
 
 	gst.Init(nil)
@@ -81,470 +105,824 @@ func runPipeline(mainLoop *glib.MainLoop) error {
 		linuxVariant = detectLinuxVariant()
 	}
 
-	host, err := promptString(reader, "Video UDP host", "127.0.0.1")
-	if err != nil {
-		return err
+	// The transport is asked for with the host, so command lines and
+	// profiles that already give a host keep sending RTP.
+	if opts.Transport == "" {
+		opts.Transport = TransportRTP
+		switch {
+		case opts.WHIPURL != "":
+			opts.Transport = TransportWHIP
+		case opts.RTMPURL != "":
+			opts.Transport = TransportRTMP
+		case opts.VideoHost == "":
+			opts.Transport, err = promptTransport(reader)
+			if err != nil {
+				return err
+			}
+		}
 	}
-	port, err := promptPort(reader, "Video UDP port", 5000)
-	if err != nil {
-		return err
+	if opts.Transport == TransportSRT && opts.SRTMode == "" {
+		opts.SRTMode = SRTCaller
+		if opts.VideoHost == "" {
+			opts.SRTMode, err = promptSRTMode(reader)
+			if err != nil {
+				return err
+			}
+		}
 	}
-	codec, err := promptCodec(reader, platform, linuxVariant)
-	if err != nil {
-		return err
+	// MPEG-TS carries the audio in the video's stream, and an RTSP server
+	// serves both from one address, so there is no audio host or port to
+	// ask for. WHIP and RTMP have a URL instead of a host and port.
+	muxed := isMuxed(opts.Transport)
+	oneHost := opts.Transport != TransportRTP
+	whip := opts.Transport == TransportWHIP
+	rtmp := opts.Transport == TransportRTMP
+	if whip && opts.WHIPURL == "" {
+		for {
+			opts.WHIPURL, err = promptString(reader, "WHIP endpoint URL", "http://127.0.0.1:8080/whip")
+			if err != nil {
+				return err
+			}
+			if err := validateWHIPURL(opts.WHIPURL); err != nil {
+				fmt.Println(err)
+				continue
+			}
+			break
+		}
 	}
-	linuxH264Mode := LinuxH264VAAPI
-	if platform == "linux" && codec == CodecH264 && linuxVariant != LinuxJetson {
-		linuxH264Mode, err = promptLinuxH264Mode(reader, linuxVariant)
+	if rtmp && opts.RTMPURL == "" {
+		for {
+			opts.RTMPURL, err = promptString(reader, "RTMP ingest URL", "rtmp://127.0.0.1:1935/live")
+			if err != nil {
+				return err
+			}
+			if err := validateRTMPURL(opts.RTMPURL); err != nil {
+				fmt.Println(err)
+				continue
+			}
+			break
+		}
+		if flagOrEnv(opts.RTMPKey, rtmpKeyEnv) == "" {
+			key, err := promptString(reader, "RTMP stream key (- if the URL has it)", "-")
+			if err != nil {
+				return err
+			}
+			if key != "-" {
+				opts.RTMPKey = key
+			}
+		}
+	}
+	hostLabel, defaultHost, portLabel, defaultPort := transportPrompts(opts.Transport, opts.SRTMode)
+	if opts.VideoHost == "" && !whip && !rtmp {
+		opts.VideoHost, err = promptString(reader, hostLabel, defaultHost)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.VideoPort == 0 && !whip && !rtmp {
+		opts.VideoPort, err = promptPort(reader, portLabel, defaultPort)
 		if err != nil {
 			return err
 		}
 	}
+	if opts.Codec == "" && rtmp {
+		opts.Codec = CodecH264
+	}
+	if opts.Codec == "" {
+		opts.Codec, err = promptCodec(reader, platform, linuxVariant, opts.LinuxH264Mode, opts.LinuxH265Mode)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.LinuxH264Mode == "" {
+		opts.LinuxH264Mode = LinuxH264VAAPI
+		if platform == "linux" && opts.Codec == CodecH264 && linuxVariant != LinuxJetson {
+			opts.LinuxH264Mode, err = promptLinuxH264Mode(reader, platform, linuxVariant)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-	videoDevice, err := selectDevice(reader, "Video/Source", "video/x-raw", "Select a camera")
-	if err != nil {
-		return err
+	if opts.LinuxH265Mode == "" {
+		opts.LinuxH265Mode = LinuxH265VAAPI
+		if platform == "linux" && opts.Codec == CodecH265 && linuxVariant != LinuxJetson && linuxVariant != LinuxRock5 {
+			opts.LinuxH265Mode, err = promptLinuxH265Mode(reader, platform, linuxVariant)
+			if err != nil {
+				return err
+			}
+		}
 	}
-	mode, err := pickMode(reader, videoDevice.GetCaps())
+	videoSource, videoCaps, file, err := resolveVideoSource(reader, opts, platform)
 	if err != nil {
 		return err
 	}
+	// Passing a file's encoded video through needs no encoder.
+	var encoder string
+	if !videoSource.Passthrough {
+		encoder, err = selectEncoder(platform, linuxVariant, opts.Codec, opts.LinuxH264Mode, opts.LinuxH265Mode)
+		if err != nil {
+			return err
+		}
+	}
+	sourceMedia := "video/x-raw"
+	if platform == "linux" && linuxVariant != LinuxJetson && linuxVariant != LinuxRock5 &&
+		opts.Codec == CodecH264 && opts.LinuxH264Mode == LinuxH264CameraH264 {
+		if videoSource.Kind != SourceDevice {
+			return errors.New("camera-h264 mode needs a camera that outputs H264")
+		}
+		sourceMedia = "video/x-h264"
+	}
+	var mode Mode
+	if !videoSource.Passthrough {
+		mode, err = pickMode(reader, videoCaps, Mode{
+			Format:    opts.Format,
+			Width:     opts.Width,
+			Height:    opts.Height,
+			Framerate: opts.Framerate,
+		}, sourceMedia)
+		if err != nil {
+			return err
+		}
+		opts.Format, opts.Width, opts.Height, opts.Framerate = mode.Format, mode.Width, mode.Height, mode.Framerate
+	}
 
-	audioHost, err := promptString(reader, "Audio UDP host", host)
-	if err != nil {
-		return err
+	if opts.AudioHost == "" && !oneHost {
+		opts.AudioHost, err = promptString(reader, "Audio UDP host", opts.VideoHost)
+		if err != nil {
+			return err
+		}
 	}
-	audioPort, err := promptPort(reader, "Audio UDP port", 5001)
-	if err != nil {
-		return err
+	if isMulticast(opts.VideoHost) || isMulticast(opts.AudioHost) {
+		if err := promptMulticast(reader, opts); err != nil {
+			return err
+		}
 	}
-	audioCodec, err := promptAudioCodec(reader)
-	if err != nil {
+	if opts.AudioPort == 0 && !oneHost {
+		opts.AudioPort, err = promptPort(reader, "Audio UDP port", 5001)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.AudioCodec == "" && muxed {
+		opts.AudioCodec = AudioOpus
+	}
+	if opts.AudioCodec == "" && rtmp {
+		opts.AudioCodec = AudioAAC
+	}
+	if opts.AudioCodec == "" {
+		opts.AudioCodec, err = promptAudioCodec(reader)
+		if err != nil {
+			return err
+		}
+	}
+	if err := checkTransport(opts); err != nil {
 		return err
 	}
-	audioDevice, err := selectDevice(reader, "Audio/Source", "audio/x-raw", "Select an audio device")
+	audioSource, err := resolveAudioSource(reader, opts, platform, file)
 	if err != nil {
 		return err
 	}
 
-	sourceName := "avfvideosrc"
-	if platform == "linux" {
-		sourceName = "v4l2src"
-	}
-	if elem := videoDevice.CreateElement(""); elem != nil {
-		if factory := elem.GetFactory(); factory != nil && factory.GetName() != "" {
-			sourceName = factory.GetName()
+	if opts.SaveProfile != "" {
+		if err := saveProfile(opts.SaveProfile, opts); err != nil {
+			return err
 		}
+		fmt.Printf("Saved profile to %s\n", opts.SaveProfile)
 	}
 
-	videoDeviceProp := buildDeviceProperty(videoDevice)
-	videoPipelineStr := buildVideoPipelineString(platform, linuxVariant, sourceName, videoDeviceProp, mode, host, port, codec, linuxH264Mode)
+	videoDesc := buildVideoPipeline(platform, linuxVariant, videoSource, mode, opts.Codec, opts.LinuxH264Mode, encoder)
 
-	audioSourceName := "osxaudiosrc"
-	if platform == "linux" {
-		audioSourceName = "pipewiresrc"
+	audioDesc := buildAudioPipeline(platform, audioSource, opts.AudioCodec)
+
+	if opts.Transport == TransportRTSP {
+		return runRTSPServer(mainLoop, opts, videoDesc, audioDesc)
 	}
-	if elem := audioDevice.CreateElement(""); elem != nil {
-		if factory := elem.GetFactory(); factory != nil && factory.GetName() != "" {
-			audioSourceName = factory.GetName()
+	if whip {
+		loop := (videoSource.Kind == SourceFile || audioSource.Kind == SourceFile) && opts.Loop
+		return runWHIPClient(mainLoop, opts, videoDesc, audioDesc, loop)
+	}
+	if rtmp {
+		aacEncoder, err := selectAACEncoder(platform)
+		if err != nil {
+			return err
+		}
+		desc := buildRTMPPipeline(videoDesc, audioDesc, aacEncoder, opts.RTMPURL, flagOrEnv(opts.RTMPKey, rtmpKeyEnv))
+		loop := (videoSource.Kind == SourceFile || audioSource.Kind == SourceFile) && opts.Loop
+		return runMuxedPipeline(mainLoop, desc, "rtmp", loop, opts.DryRun)
+	}
+	if muxed {
+		desc := buildMPEGTSPipeline(videoDesc, audioDesc, opts.Codec)
+		if opts.Transport == TransportSRT {
+			srt := &srtConfig{
+				Mode:       opts.SRTMode,
+				Host:       opts.VideoHost,
+				Port:       opts.VideoPort,
+				Latency:    opts.SRTLatency,
+				Passphrase: flagOrEnv(opts.SRTPassphrase, srtPassphraseEnv),
+				PBKeyLen:   opts.SRTPBKeyLen,
+				StreamID:   opts.SRTStreamID,
+			}
+			if err := validateSRT(srt.Latency, srt.Passphrase, srt.PBKeyLen); err != nil {
+				return err
+			}
+			desc.Add("srtsink", append(srt.props(), prop("sync", false))...)
+		} else {
+			desc.UDPSink(opts.VideoHost, opts.VideoPort, multicastConfigFor(opts, opts.VideoHost).sinkProps()...)
+		}
+		loop := (videoSource.Kind == SourceFile || audioSource.Kind == SourceFile) && opts.Loop
+		return runMuxedPipeline(mainLoop, desc, string(opts.Transport), loop, opts.DryRun)
+	}
+
+	videoRTCPPort, audioRTCPPort := defaultRTCPPorts(opts.VideoPort, opts.AudioPort)
+	video := rtpStream{
+		Label:     "video",
+		Chain:     videoDesc,
+		Host:      opts.VideoHost,
+		Port:      opts.VideoPort,
+		RTCPPort:  orDefault(opts.VideoRTCPPort, videoRTCPPort),
+		ClockRate: 90000,
+		PT:        96,
+	}
+	video.Multicast = multicastConfigFor(opts, video.Host)
+	video.RTCPRecvPort = orDefault(opts.VideoRTCPRecvPort, defaultRTCPRecvPort(video.Host, video.RTCPPort))
+	audio := rtpStream{
+		Label:     "audio",
+		Chain:     audioDesc,
+		Host:      opts.AudioHost,
+		Port:      opts.AudioPort,
+		RTCPPort:  orDefault(opts.AudioRTCPPort, audioRTCPPort),
+		ClockRate: audioClockRate(opts.AudioCodec),
+		PT:        audioPayloadType(opts.AudioCodec),
+	}
+	audio.Multicast = multicastConfigFor(opts, audio.Host)
+	audio.RTCPRecvPort = orDefault(opts.AudioRTCPRecvPort, defaultRTCPRecvPort(audio.Host, audio.RTCPPort))
+	if opts.RTX {
+		video.RTXPT = orDefault(opts.VideoRTXPT, 97)
+		audio.RTXPT = orDefault(opts.AudioRTXPT, 97)
+		video.RTXHistory = orDefault(opts.RTXHistory, 1000)
+		audio.RTXHistory = video.RTXHistory
+	}
+	if opts.FEC {
+		video.REDPT = orDefault(opts.REDPT, 98)
+		video.FECPT = orDefault(opts.FECPT, 99)
+		video.FECPercent = orDefault(opts.FECOverhead, 20)
+	}
+	if opts.SRTP {
+		if opts.SDPHTTP != "" && !opts.SDPHTTPKey {
+			return errors.New("sdp-http would serve the SRTP key in the clear; add --sdp-http-key to allow it")
+		}
+		srtp, err := loadSRTPConfig(opts.SRTPKey, opts.SRTPKeyFile, opts.SRTPRotate, opts.SRTPCipher, opts.SRTPAuth)
+		if err != nil {
+			return err
 		}
+		video.SRTP, audio.SRTP = srtp, srtp
 	}
-	audioDeviceProp := buildDeviceProperty(audioDevice)
-	audioPipelineStr := buildAudioPipelineString(platform, audioSourceName, audioDeviceProp, audioHost, audioPort, audioCodec)
-
-	// Let GStreamer create a pipeline from the selected parameters.
-	videoPipeline, err := gst.NewPipelineFromString(videoPipelineStr)
-	if err != nil {
+	if err := checkPayloadTypes(video); err != nil {
 		return err
 	}
-	audioPipeline, err := gst.NewPipelineFromString(audioPipelineStr)
-	if err != nil {
+	if err := checkRTPPorts(video, audio); err != nil {
 		return err
 	}
 
-	allPipelines := []*gst.Pipeline{videoPipeline, audioPipeline}
-	addPipelineWatch(videoPipeline, "video", mainLoop, allPipelines)
-	addPipelineWatch(audioPipeline, "audio", mainLoop, allPipelines)
+	// Each pipeline sends its streams through its own rtpbin. With --sync
+	// both streams share one pipeline, clock and RTCP session.
+	groups := [][]rtpStream{{video}, {audio}}
+	labels := []string{"video", "audio"}
+	loops := []bool{videoSource.Kind == SourceFile && opts.Loop, audioSource.Kind == SourceFile && opts.Loop}
+	if opts.Sync {
+		groups = [][]rtpStream{{video, audio}}
+		labels = []string{"stream"}
+		loops = []bool{loops[0] || loops[1]}
+	}
+	descs := make([]*PipelineDesc, len(groups))
+	for i, group := range groups {
+		descs[i] = buildRTPBinPipeline(group...)
+	}
+
+	if opts.DryRun {
+		for _, desc := range descs {
+			fmt.Println(desc.LaunchCommand())
+		}
+		if opts.RTX {
+			fmt.Println("# rtprtxsend is added through rtpbin's request-aux-sender signal, which gst-launch-1.0 cannot express")
+		}
+		return nil
+	}
+
+	// Instantiate and link the elements chosen from the selected parameters.
+	pipelines := make([]*gst.Pipeline, len(descs))
+	for i, desc := range descs {
+		if pipelines[i], err = desc.Build(); err != nil {
+			return err
+		}
+	}
+	var sdpStreams []sdpStream
+	for i, pipeline := range pipelines {
+		addPipelineWatch(pipeline, labels[i], mainLoop, pipelines, loops[i])
+		if err := watchReceiverReports(pipeline, groups[i]); err != nil {
+			return err
+		}
+		for _, s := range groups[i] {
+			sdpStreams = append(sdpStreams, sdpStream{Pipeline: pipeline, Media: s.Label, Host: s.Host, Port: s.Port, RTCPPort: s.RTCPPort, RTXPT: s.RTXPT, REDPT: s.REDPT, FECPT: s.FECPT, SRTP: s.SRTP, TTL: opts.MulticastTTL})
+		}
+	}
+
+	if opts.SDPFile != "" || opts.SDPHTTP != "" {
+		if err := publishSDP(sdpStreams, opts.SDPFile, opts.SDPHTTP); err != nil {
+			return err
+		}
+	}
 
 	// Start the pipelines
-	videoPipeline.SetState(gst.StatePlaying)
-	audioPipeline.SetState(gst.StatePlaying)
+	for _, pipeline := range pipelines {
+		pipeline.SetState(gst.StatePlaying)
+	}
 
 	// Block on the main loop
 	return mainLoop.RunError()
 }
 
 func buildDeviceProperty(device *gst.Device) string {
+	pr := deviceProperty(device)
+	switch pr.Name {
+	case "":
+		return ""
+	case "device-index":
+		return pr.Name + "=" + pr.Value
+	default:
+		return fmt.Sprintf("%s=%s", pr.Name, strconv.Quote(pr.Value))
+	}
+}
+
+// deviceProperty returns the source element property that selects the
+// given device, or a zero Property if there is none.
+func deviceProperty(device *gst.Device) Property {
 	if device != nil {
 		if props := device.GetProperties(); props != nil {
 			values := props.Values()
 			if s := stringProp(values, "device"); s != "" {
-				return fmt.Sprintf("device=%s", strconv.Quote(s))
+				return prop("device", s)
 			}
 			if s := stringProp(values, "path"); s != "" {
-				return fmt.Sprintf("device=%s", strconv.Quote(s))
+				return prop("device", s)
 			}
 			if s := stringProp(values, "target-object"); s != "" {
-				return fmt.Sprintf("target-object=%s", strconv.Quote(s))
+				return prop("target-object", s)
 			}
 			if s := stringProp(values, "node.id"); s != "" {
-				return fmt.Sprintf("target-object=%s", strconv.Quote(s))
+				return prop("target-object", s)
 			}
 			if i := intProp(values, "device-index"); i >= 0 {
-				return fmt.Sprintf("device-index=%d", i)
+				return prop("device-index", i)
 			}
 		}
 	}
-	return ""
+	return Property{}
 }
 
-func buildVideoPipelineString(platform string, linuxVariant LinuxVariant, sourceName, deviceProp string, mode Mode, host string, port int, codec Codec, linuxH264Mode LinuxH264Mode) string {
-	devicePrefix := devicePropPrefix(deviceProp)
+// buildVideoPipeline builds the sender for codec up to its payloader; the
+// caller appends the network sink. encoder is the element picked from
+// encoderChain; builders with a single choice ignore it.
+func buildVideoPipeline(platform string, linuxVariant LinuxVariant, src Source, mode Mode, codec Codec, linuxH264Mode LinuxH264Mode, encoder string) *PipelineDesc {
+	if src.Passthrough {
+		return buildPassthroughVideoPipeline(src, codec)
+	}
 	switch platform {
 	case "linux":
-		return buildLinuxVideoPipelineString(linuxVariant, sourceName, devicePrefix, mode, host, port, codec, linuxH264Mode)
+		return buildLinuxVideoPipeline(linuxVariant, src, mode, codec, linuxH264Mode, encoder)
 	default:
-		return buildDarwinVideoPipelineString(sourceName, devicePrefix, mode, host, port, codec)
+		return buildDarwinVideoPipeline(src, mode, codec, encoder)
 	}
 }
 
-func buildDarwinVideoPipelineString(sourceName, devicePrefix string, mode Mode, host string, port int, codec Codec) string {
-	caps := fmt.Sprintf("video/x-raw,width=%d,height=%d,framerate=%s,format=%s",
-		mode.Width, mode.Height, mode.Framerate, mode.Format)
+func buildDarwinVideoPipeline(src Source, mode Mode, codec Codec, encoder string) *PipelineDesc {
+	p := &PipelineDesc{}
+	addVideoSource(p, src, mode, prop("do-stats", true), prop("do-timestamp", true), src.Device)
 	switch codec {
 	case CodecH265:
-		return fmt.Sprintf(
-			"%s do-stats=true do-timestamp=true %s! %s ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"vtenc_h265_hw realtime=true allow-frame-reordering=false ! "+
-				"h265parse ! rtph265pay config-interval=-1 aggregate-mode=zero-latency ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, caps, host, port,
-		)
+		if strings.HasPrefix(encoder, "vtenc_") {
+			p.Caps(rawVideoCaps(mode, mode.Format)).
+				LeakyQueue(1).
+				Add(encoder, prop("realtime", true), prop("allow-frame-reordering", false))
+		} else {
+			addSoftwareEncoder(p, mode, encoder)
+		}
+		p.Add("h265parse").
+			Add("rtph265pay", prop("config-interval", -1), prop("aggregate-mode", "zero-latency"))
 	case CodecVP8:
-		return fmt.Sprintf(
-			"%s do-stats=true do-timestamp=true %s! video/x-raw,width=%d,height=%d,framerate=%s ! "+
-				"videoconvert ! video/x-raw,format=I420 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"vp8enc deadline=1 ! "+
-				"rtpvp8pay ! udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		p.Caps(rawVideoCaps(mode, "")).
+			Add("videoconvert").Caps("video/x-raw,format=I420").
+			LeakyQueue(1).
+			Add("vp8enc", prop("deadline", 1)).
+			Add("rtpvp8pay")
 	case CodecVP9:
-		return fmt.Sprintf(
-			"%s do-stats=true do-timestamp=true %s! video/x-raw,width=%d,height=%d,framerate=%s ! "+
-				"videoconvert ! video/x-raw,format=I420 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"vp9enc deadline=1 cpu-used=8 threads=4 lag-in-frames=0 ! "+
-				"vp9parse ! rtpvp9pay ! udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		p.Caps(rawVideoCaps(mode, "")).
+			Add("videoconvert").Caps("video/x-raw,format=I420").
+			LeakyQueue(1).
+			Add("vp9enc", prop("deadline", 1), prop("cpu-used", 8), prop("threads", 4), prop("lag-in-frames", 0)).
+			Add("vp9parse").
+			Add("rtpvp9pay")
 	case CodecAV1:
-		return fmt.Sprintf(
-			"%s do-stats=true do-timestamp=true %s! video/x-raw,width=%d,height=%d,framerate=%s ! "+
-				"videoconvert ! video/x-raw,format=I420 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"svtav1enc ! "+
-				"av1parse ! rtpav1pay ! udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		addSoftwareEncoder(p, mode, encoder).
+			Add("av1parse").
+			Add("rtpav1pay")
 	default:
-		return fmt.Sprintf(
-			"%s do-stats=true do-timestamp=true %s! %s ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"vtenc_h264_hw realtime=true ! "+
-				"h264parse ! rtph264pay config-interval=-1 aggregate-mode=zero-latency ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, caps, host, port,
-		)
+		if strings.HasPrefix(encoder, "vtenc_") {
+			p.Caps(rawVideoCaps(mode, mode.Format)).
+				LeakyQueue(1).
+				Add(encoder, prop("realtime", true))
+		} else {
+			addSoftwareEncoder(p, mode, encoder)
+		}
+		p.Add("h264parse").
+			Add("rtph264pay", prop("config-interval", -1), prop("aggregate-mode", "zero-latency"))
 	}
+	return p
 }
 
-func buildLinuxVideoPipelineString(linuxVariant LinuxVariant, sourceName, devicePrefix string, mode Mode, host string, port int, codec Codec, linuxH264Mode LinuxH264Mode) string {
+func buildLinuxVideoPipeline(linuxVariant LinuxVariant, src Source, mode Mode, codec Codec, linuxH264Mode LinuxH264Mode, encoder string) *PipelineDesc {
 	if linuxVariant == LinuxJetson {
-		return buildJetsonVideoPipelineString(sourceName, devicePrefix, mode, host, port, codec)
+		return buildJetsonVideoPipeline(src, mode, codec)
 	}
 	if linuxVariant == LinuxRock5 {
-		return buildRock5VideoPipelineString(sourceName, devicePrefix, mode, host, port, codec)
+		return buildRock5VideoPipeline(src, mode, codec, encoder)
 	}
 	if codec == CodecH264 {
-		return buildLinuxH264PipelineString(sourceName, devicePrefix, mode, host, port, linuxH264Mode)
+		return buildLinuxH264Pipeline(src, mode, linuxH264Mode, encoder)
 	}
+	p := &PipelineDesc{}
+	addVideoSource(p, src, mode, prop("do-timestamp", true), src.Device, prop("io-mode", "dmabuf"))
 	switch codec {
 	case CodecH265:
-		return fmt.Sprintf(
-			"%s do-timestamp=true %sio-mode=dmabuf ! vaapipostproc ! "+
-				"video/x-raw(memory:VASurface),format=NV12,width=%d,height=%d,framerate=%s ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"vaapih265enc ! h265parse ! rtph265pay config-interval=-1 aggregate-mode=zero-latency ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		addVAEncoder(p, mode, encoder).
+			Add("h265parse").
+			Add("rtph265pay", prop("config-interval", -1), prop("aggregate-mode", "zero-latency"))
 	case CodecVP8:
-		return fmt.Sprintf(
-			"%s do-timestamp=true %sio-mode=dmabuf ! "+
-				"video/x-raw,width=%d,height=%d,framerate=%s ! "+
-				"videoconvert ! video/x-raw,format=I420 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"vp8enc deadline=1 ! rtpvp8pay ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		p.Caps(rawVideoCaps(mode, "")).
+			Add("videoconvert").Caps("video/x-raw,format=I420").
+			LeakyQueue(1).
+			Add("vp8enc", prop("deadline", 1)).
+			Add("rtpvp8pay")
 	case CodecVP9:
-		return fmt.Sprintf(
-			"%s do-timestamp=true %sio-mode=dmabuf ! "+
-				"video/x-raw,width=%d,height=%d,framerate=%s ! "+
-				"videoconvert ! video/x-raw,format=I420 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"vp9enc deadline=1 cpu-used=4 ! vp9parse ! rtpvp9pay ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		p.Caps(rawVideoCaps(mode, "")).
+			Add("videoconvert").Caps("video/x-raw,format=I420").
+			LeakyQueue(1).
+			Add("vp9enc", prop("deadline", 1), prop("cpu-used", 4)).
+			Add("vp9parse").
+			Add("rtpvp9pay")
 	case CodecAV1:
-		return fmt.Sprintf(
-			"%s do-timestamp=true %sio-mode=dmabuf ! "+
-				"video/x-raw,width=%d,height=%d,framerate=%s ! "+
-				"videoconvert ! video/x-raw,format=I420 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"svtav1enc ! av1parse ! rtpav1pay ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		addSoftwareEncoder(p, mode, encoder).
+			Add("av1parse").
+			Add("rtpav1pay")
 	default:
-		return buildLinuxH264PipelineString(sourceName, devicePrefix, mode, host, port, linuxH264Mode)
+		return buildLinuxH264Pipeline(src, mode, linuxH264Mode, encoder)
 	}
+	return p
 }
 
-func buildRock5VideoPipelineString(sourceName, devicePrefix string, mode Mode, host string, port int, codec Codec) string {
+func buildRock5VideoPipeline(src Source, mode Mode, codec Codec, encoder string) *PipelineDesc {
+	if codec == CodecVP9 || codec == CodecAV1 {
+		return buildLinuxVideoPipeline(LinuxGeneric, src, mode, codec, LinuxH264VAAPI, encoder)
+	}
+	p := &PipelineDesc{}
+	addVideoSource(p, src, mode, src.Device).
+		Caps(rawVideoCaps(mode, "YUY2")).
+		Add("videoconvert").Caps("video/x-raw,format=NV12").
+		LeakyQueue(1)
 	switch codec {
 	case CodecH265:
-		return fmt.Sprintf(
-			"%s %s! video/x-raw,width=%d,height=%d,framerate=%s,format=YUY2 ! "+
-				"videoconvert ! video/x-raw,format=NV12 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"mpph265enc ! "+
-				"rtph265pay config-interval=1 aggregate-mode=zero-latency ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		p.Add("mpph265enc").
+			Add("rtph265pay", prop("config-interval", 1), prop("aggregate-mode", "zero-latency"))
 	case CodecVP8:
-		return fmt.Sprintf(
-			"%s %s! video/x-raw,width=%d,height=%d,framerate=%s,format=YUY2 ! "+
-				"videoconvert ! video/x-raw,format=NV12 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"mppvp8enc ! "+
-				"rtpvp8pay ! udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
-	case CodecVP9:
-		fallthrough
-	case CodecAV1:
-		return buildLinuxVideoPipelineString(LinuxGeneric, sourceName, devicePrefix, mode, host, port, codec, LinuxH264VAAPI)
+		p.Add("mppvp8enc").
+			Add("rtpvp8pay")
 	default:
-		return fmt.Sprintf(
-			"%s %s! video/x-raw,width=%d,height=%d,framerate=%s,format=YUY2 ! "+
-				"videoconvert ! video/x-raw,format=NV12 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"mpph264enc level=40 profile=100 ! "+
-				"rtph264pay config-interval=-1 aggregate-mode=zero-latency ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		p.Add("mpph264enc", prop("level", 40), prop("profile", 100)).
+			Add("rtph264pay", prop("config-interval", -1), prop("aggregate-mode", "zero-latency"))
 	}
+	return p
 }
 
-func buildJetsonVideoPipelineString(sourceName, devicePrefix string, mode Mode, host string, port int, codec Codec) string {
+func buildJetsonVideoPipeline(src Source, mode Mode, codec Codec) *PipelineDesc {
+	p := &PipelineDesc{}
+	addVideoSource(p, src, mode, src.Device)
+	if src.Kind != SourceDevice || mode.Format == mjpegFormat {
+		// Test patterns, decoded files and decoded JPEG are in system
+		// memory; nvvidconv uploads them.
+		p.Add("nvvidconv")
+	}
+	p.Caps(fmt.Sprintf("video/x-raw(memory:NVMM),width=%d,height=%d,framerate=%s", mode.Width, mode.Height, mode.Framerate)).
+		LeakyQueue(1)
 	switch codec {
 	case CodecH265:
-		return fmt.Sprintf(
-			"%s %s! video/x-raw(memory:NVMM),width=%d,height=%d,framerate=%s ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"nvv4l2h265enc preset-level=3 profile=0 bitrate=30000000 ! "+
-				"capsfilter caps=video/x-h265,level=(string)4 ! "+
-				"queue max-size-buffers=3 leaky=downstream ! "+
-				"rtph265pay config-interval=1 aggregate-mode=zero-latency ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		p.Add("nvv4l2h265enc", prop("preset-level", 3), prop("profile", 0), prop("bitrate", 30000000)).
+			Caps("video/x-h265,level=(string)4").
+			LeakyQueue(3).
+			Add("rtph265pay", prop("config-interval", 1), prop("aggregate-mode", "zero-latency"))
 	case CodecVP8:
-		return fmt.Sprintf(
-			"%s %s! video/x-raw(memory:NVMM),width=%d,height=%d,framerate=%s ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"nvv4l2vp8enc bitrate=20000000 ! "+
-				"rtpvp8pay ! udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		p.Add("nvv4l2vp8enc", prop("bitrate", 20000000)).
+			Add("rtpvp8pay")
 	case CodecVP9:
-		return fmt.Sprintf(
-			"%s %s! video/x-raw(memory:NVMM),width=%d,height=%d,framerate=%s ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"nvv4l2vp9enc bitrate=30000000 ! "+
-				"rtpvp9pay ! udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		p.Add("nvv4l2vp9enc", prop("bitrate", 30000000)).
+			Add("rtpvp9pay")
 	default:
-		return fmt.Sprintf(
-			"%s %s! video/x-raw(memory:NVMM),width=%d,height=%d,framerate=%s ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"nvv4l2h264enc preset-level=3 profile=4 bitrate=20000000 ! "+
-				"capsfilter caps=video/x-h264,level=(string)4 ! "+
-				"queue max-size-buffers=3 leaky=downstream ! "+
-				"rtph264pay config-interval=-1 aggregate-mode=zero-latency ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		p.Add("nvv4l2h264enc", prop("preset-level", 3), prop("profile", 4), prop("bitrate", 20000000)).
+			Caps("video/x-h264,level=(string)4").
+			LeakyQueue(3).
+			Add("rtph264pay", prop("config-interval", -1), prop("aggregate-mode", "zero-latency"))
 	}
+	return p
 }
 
-func buildLinuxH264PipelineString(sourceName, devicePrefix string, mode Mode, host string, port int, linuxH264Mode LinuxH264Mode) string {
+func buildLinuxH264Pipeline(src Source, mode Mode, linuxH264Mode LinuxH264Mode, encoder string) *PipelineDesc {
+	p := &PipelineDesc{}
 	switch linuxH264Mode {
 	case LinuxH264RaspiV4L2:
-		return fmt.Sprintf(
-			"%s do-timestamp=true %sio-mode=dmabuf ! "+
-				"video/x-raw,width=%d,height=%d,framerate=%s,format=NV12 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"v4l2h264enc capture-io-mode=dmabuf output-io-mode=dmabuf ! "+
-				"capsfilter caps=video/x-h264,level=(string)4.1 ! "+
-				"queue max-size-buffers=3 leaky=downstream ! "+
-				"rtph264pay config-interval=-1 aggregate-mode=zero-latency ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		addVideoSource(p, src, mode, prop("do-timestamp", true), src.Device, prop("io-mode", "dmabuf")).
+			Caps(rawVideoCaps(mode, "NV12")).
+			LeakyQueue(1).
+			Add("v4l2h264enc", prop("capture-io-mode", "dmabuf"), prop("output-io-mode", "dmabuf")).
+			Caps("video/x-h264,level=(string)4.1").
+			LeakyQueue(3).
+			Add("rtph264pay", prop("config-interval", -1), prop("aggregate-mode", "zero-latency"))
 	case LinuxH264Libcamera:
-		return fmt.Sprintf(
-			"libcamerasrc %s! video/x-raw,width=%d,height=%d,framerate=%s,format=YUY2,interlace-mode=progressive ! "+
-				"v4l2h264enc extra-controls=\"encode,h264_profile=4,h264_level=12,video_bitrate=20000000\" ! "+
-				"capsfilter caps=video/x-h264,level=(string)4.1 ! "+
-				"queue max-size-buffers=3 leaky=downstream ! "+
-				"rtph264pay config-interval=-1 aggregate-mode=zero-latency ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		if src.Kind == SourceDevice {
+			src.Factory = "libcamerasrc"
+		}
+		addVideoSource(p, src, mode, src.Device).
+			Caps(rawVideoCaps(mode, "YUY2")+",interlace-mode=progressive").
+			Add("v4l2h264enc", prop("extra-controls", "encode,h264_profile=4,h264_level=12,video_bitrate=20000000")).
+			Caps("video/x-h264,level=(string)4.1").
+			LeakyQueue(3).
+			Add("rtph264pay", prop("config-interval", -1), prop("aggregate-mode", "zero-latency"))
 	case LinuxH264CameraH264:
-		return fmt.Sprintf(
-			"%s do-timestamp=true %s! video/x-h264,width=%d,height=%d,framerate=%s,stream-format=byte-stream ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"h264parse ! rtph264pay config-interval=-1 aggregate-mode=zero-latency ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		p.Add(src.Factory, prop("do-timestamp", true), src.Device).
+			Caps(fmt.Sprintf("video/x-h264,width=%d,height=%d,framerate=%s,stream-format=byte-stream", mode.Width, mode.Height, mode.Framerate)).
+			LeakyQueue(1).
+			Add("h264parse").
+			Add("rtph264pay", prop("config-interval", -1), prop("aggregate-mode", "zero-latency"))
+	default:
+		addVideoSource(p, src, mode, prop("do-timestamp", true), src.Device, prop("io-mode", "dmabuf"))
+		addVAEncoder(p, mode, encoder).
+			Add("h264parse").
+			Add("rtph264pay", prop("config-interval", -1), prop("aggregate-mode", "zero-latency"))
+	}
+	return p
+}
+
+// addVAEncoder appends encoder with the VA-API post-processing it needs,
+// or the software conversion if encoder is not a VA-API element.
+func addVAEncoder(p *PipelineDesc, mode Mode, encoder string) *PipelineDesc {
+	switch {
+	case strings.HasPrefix(encoder, "vaapi"):
+		return p.Add("vaapipostproc").
+			Caps(fmt.Sprintf("video/x-raw(memory:VASurface),format=NV12,width=%d,height=%d,framerate=%s", mode.Width, mode.Height, mode.Framerate)).
+			LeakyQueue(1).
+			Add(encoder)
+	case strings.HasPrefix(encoder, "va"):
+		return p.Add("vapostproc").
+			Caps(fmt.Sprintf("video/x-raw(memory:VAMemory),format=NV12,width=%d,height=%d,framerate=%s", mode.Width, mode.Height, mode.Framerate)).
+			LeakyQueue(1).
+			Add(encoder)
 	default:
-		return fmt.Sprintf(
-			"%s do-timestamp=true %sio-mode=dmabuf ! vaapipostproc ! "+
-				"video/x-raw(memory:VASurface),format=NV12,width=%d,height=%d,framerate=%s ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"vaapih264enc ! h264parse ! rtph264pay config-interval=-1 aggregate-mode=zero-latency ! "+
-				"udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, mode.Width, mode.Height, mode.Framerate, host, port,
-		)
+		return addSoftwareEncoder(p, mode, encoder)
 	}
 }
 
-func buildAudioPipelineString(platform string, sourceName, deviceProp string, host string, port int, codec AudioCodec) string {
-	devicePrefix := devicePropPrefix(deviceProp)
+// addSoftwareEncoder appends encoder behind a conversion to I420.
+func addSoftwareEncoder(p *PipelineDesc, mode Mode, encoder string) *PipelineDesc {
+	return p.Caps(rawVideoCaps(mode, "")).
+		Add("videoconvert").Caps("video/x-raw,format=I420").
+		LeakyQueue(1).
+		Add(encoder, encoderProps(encoder)...)
+}
+
+// rawVideoCaps returns video/x-raw caps for mode, with format appended
+// unless it is empty.
+// rawVideoCaps is the raw video caps for mode in format. MJPEG modes are
+// decoded right after the source, so mjpegFormat leaves the format open.
+func rawVideoCaps(mode Mode, format string) string {
+	caps := fmt.Sprintf("video/x-raw,width=%d,height=%d,framerate=%s", mode.Width, mode.Height, mode.Framerate)
+	if format != "" && format != mjpegFormat {
+		caps += ",format=" + format
+	}
+	return caps
+}
+
+func buildAudioPipeline(platform string, src Source, codec AudioCodec) *PipelineDesc {
 	if platform == "linux" {
-		return buildLinuxAudioPipelineString(sourceName, devicePrefix, host, port, codec)
+		return buildLinuxAudioPipeline(src, codec)
 	}
-	return buildDarwinAudioPipelineString(sourceName, devicePrefix, host, port, codec)
+	return buildDarwinAudioPipeline(src, codec)
 }
 
-func buildDarwinAudioPipelineString(sourceName, devicePrefix string, host string, port int, codec AudioCodec) string {
+func buildDarwinAudioPipeline(src Source, codec AudioCodec) *PipelineDesc {
+	p := &PipelineDesc{}
+	addAudioSource(p, src, src.Device, prop("do-timestamp", true)).
+		Caps("audio/x-raw,rate=48000,channels=2").
+		LeakyQueue(1).
+		Add("audioconvert").
+		Add("audioresample")
 	switch codec {
 	case AudioPCMU:
-		return fmt.Sprintf(
-			"%s %sdo-timestamp=true ! audio/x-raw,rate=48000,channels=2 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"audioconvert ! audioresample ! mulawenc ! "+
-				"rtppcmupay ! udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, host, port,
-		)
+		// PCMU is 8 kHz mono; rtppcmupay would otherwise send the capture
+		// rate as its clock-rate.
+		p.Caps("audio/x-raw,rate=8000,channels=1").
+			Add("mulawenc").
+			Add("rtppcmupay")
+	case AudioAAC:
+		// buildRTMPPipeline appends the AAC encoder it finds.
 	default:
-		return fmt.Sprintf(
-			"%s %sdo-timestamp=true ! audio/x-raw,rate=48000,channels=2 ! "+
-				"queue max-size-buffers=1 leaky=downstream ! "+
-				"audioconvert ! audioresample ! opusenc ! "+
-				"rtpopuspay ! udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, host, port,
-		)
+		p.Add("opusenc").Add("rtpopuspay")
 	}
+	return p
 }
 
-func buildLinuxAudioPipelineString(sourceName, devicePrefix string, host string, port int, codec AudioCodec) string {
+func buildLinuxAudioPipeline(src Source, codec AudioCodec) *PipelineDesc {
+	p := &PipelineDesc{}
+	addAudioSource(p, src, prop("do-timestamp", true), src.Device).
+		Caps("audio/x-raw,rate=48000,channels=2").
+		Add("audioconvert").
+		Add("audioresample").
+		LeakyQueue(1)
 	switch codec {
 	case AudioPCMU:
-		return fmt.Sprintf(
-			"%s do-timestamp=true %s! audio/x-raw,rate=48000,channels=2 ! "+
-				"audioconvert ! audioresample ! queue max-size-buffers=1 leaky=downstream ! "+
-				"mulawenc ! rtppcmupay ! udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, host, port,
-		)
+		// PCMU is 8 kHz mono; rtppcmupay would otherwise send the capture
+		// rate as its clock-rate.
+		p.Caps("audio/x-raw,rate=8000,channels=1").
+			Add("mulawenc").
+			Add("rtppcmupay")
+	case AudioAAC:
+		// buildRTMPPipeline appends the AAC encoder it finds.
 	default:
-		return fmt.Sprintf(
-			"%s do-timestamp=true %s! audio/x-raw,rate=48000,channels=2 ! "+
-				"audioconvert ! audioresample ! queue max-size-buffers=1 leaky=downstream ! "+
-				"opusenc ! rtpopuspay ! udpsink host=%s port=%d sync=false async=false",
-			sourceName, devicePrefix, host, port,
-		)
+		p.Add("opusenc").Add("rtpopuspay")
 	}
+	return p
 }
 
-func pickMode(reader *bufio.Reader, caps *gst.Caps) (Mode, error) {
-	resolutions := []Mode{
-		{Width: 640, Height: 480},
-		{Width: 1024, Height: 768},
-		{Width: 1440, Height: 1080},
-		{Width: 1280, Height: 720},
-		{Width: 1920, Height: 1080},
-		{Width: 3840, Height: 2160},
-		{Width: 0, Height: 0},
-	}
-	resLabels := []string{
-		"640x480 (VGA 4:3)",
-		"1024x768 (XGA 4:3)",
-		"1440x1080 (HD 4:3)",
-		"1280x720 (HDTV 16:9)",
-		"1920x1080 (2K/FHD 16:9)",
-		"3840x2160 (4K/UHD 16:9)",
-		"Custom (enter width/height)",
-	}
-	idx, err := promptChoice(reader, "Select a resolution", resLabels)
-	if err != nil {
-		return Mode{}, err
+// commonResolutions are offered for custom modes and stand in for caps
+// whose width and height ranges are too wide to enumerate.
+var commonResolutions = []Mode{
+	{Width: 640, Height: 480},
+	{Width: 1024, Height: 768},
+	{Width: 1440, Height: 1080},
+	{Width: 1280, Height: 720},
+	{Width: 1920, Height: 1080},
+	{Width: 3840, Height: 2160},
+}
+
+var commonResolutionLabels = []string{
+	"640x480 (VGA 4:3)",
+	"1024x768 (XGA 4:3)",
+	"1440x1080 (HD 4:3)",
+	"1280x720 (HDTV 16:9)",
+	"1920x1080 (2K/FHD 16:9)",
+	"3840x2160 (4K/UHD 16:9)",
+}
+
+// commonFramerates stand in for framerate ranges.
+var commonFramerates = []int{5, 10, 15, 20, 24, 25, 30, 50, 60, 90, 120}
+
+// maxRangeSteps is the largest int range that is enumerated value by value.
+const maxRangeSteps = 16
+
+// pickMode selects a capture mode for the source media type. Fields already
+// set in preset are kept; a mode that does not intersect the device caps is
+// rejected.
+func pickMode(reader *bufio.Reader, caps *gst.Caps, preset Mode, media string) (Mode, error) {
+	needFormat := media == "video/x-raw"
+	complete := preset.Width > 0 && preset.Height > 0 && preset.Framerate != "" && (preset.Format != "" || !needFormat)
+	if complete {
+		if !modeSupported(caps, media, preset) {
+			return Mode{}, fmt.Errorf("mode %s is not supported by the device caps", preset)
+		}
+		return preset, nil
 	}
 
-	width := resolutions[idx].Width
-	height := resolutions[idx].Height
-	if width == 0 && height == 0 {
-		width, err = promptInt(reader, "Width", 640)
-		if err != nil {
-			return Mode{}, err
+	if preset == (Mode{}) {
+		modes := extractModes(caps, media, 64)
+		if len(modes) > 0 {
+			labels := make([]string, 0, len(modes)+1)
+			for _, m := range modes {
+				labels = append(labels, m.String())
+			}
+			labels = append(labels, "Custom (enter width/height/framerate)")
+			idx, err := promptChoice(reader, "Select a mode", labels)
+			if err != nil {
+				return Mode{}, err
+			}
+			if idx < len(modes) {
+				return modes[idx], nil
+			}
 		}
-		height, err = promptInt(reader, "Height", 480)
-		if err != nil {
-			return Mode{}, err
+	}
+
+	for {
+		mode := preset
+		var err error
+		if mode.Width == 0 || mode.Height == 0 {
+			mode.Width, mode.Height, err = promptResolution(reader, caps, media)
+			if err != nil {
+				return Mode{}, err
+			}
+		}
+		if mode.Format == "" && needFormat {
+			mode.Format, err = promptFormat(reader)
+			if err != nil {
+				return Mode{}, err
+			}
+		}
+		if mode.Framerate == "" {
+			mode.Framerate, err = promptFraction(reader, "Framerate (num/den)", "30/1")
+			if err != nil {
+				return Mode{}, err
+			}
+		}
+		if modeSupported(caps, media, mode) {
+			return mode, nil
+		}
+		if preset != (Mode{}) {
+			return Mode{}, fmt.Errorf("mode %s is not supported by the device caps", mode)
 		}
+		fmt.Printf("Mode %s is not supported by the device caps.\n", mode)
 	}
+}
 
-	format, err := promptFormat(reader)
+// promptResolution offers the common resolutions that the device caps allow.
+func promptResolution(reader *bufio.Reader, caps *gst.Caps, media string) (int, int, error) {
+	var options []Mode
+	var labels []string
+	for i, res := range commonResolutions {
+		if modeSupported(caps, media, res) {
+			options = append(options, res)
+			labels = append(labels, commonResolutionLabels[i])
+		}
+	}
+	labels = append(labels, "Custom (enter width/height)")
+	idx, err := promptChoice(reader, "Select a resolution", labels)
 	if err != nil {
-		return Mode{}, err
+		return 0, 0, err
 	}
-	fps, err := promptFraction(reader, "Framerate (num/den)", "30/1")
+	if idx < len(options) {
+		return options[idx].Width, options[idx].Height, nil
+	}
+
+	width, err := promptInt(reader, "Width", 640)
 	if err != nil {
-		return Mode{}, err
+		return 0, 0, err
 	}
-	if caps != nil && !caps.IsEmpty() {
-		fmt.Println("Note: chosen resolution may not be supported by the device caps.")
+	height, err := promptInt(reader, "Height", 480)
+	if err != nil {
+		return 0, 0, err
 	}
-	return Mode{
-		Format:    format,
-		Width:     width,
-		Height:    height,
-		Framerate: fps,
-	}, nil
+	return width, height, nil
 }
 
-func extractModes(caps *gst.Caps, limit int) []Mode {
+// modeSupported reports whether the set fields of mode intersect one of the
+// media structures in caps. The format is not checked because most
+// pipelines convert it after the source. Empty or ANY caps allow everything.
+func modeSupported(caps *gst.Caps, media string, mode Mode) bool {
+	if caps == nil || caps.IsEmpty() || caps.IsAny() {
+		return true
+	}
+	if media == "video/x-raw" && mode.Format == mjpegFormat {
+		media = "image/jpeg"
+	}
+	fields := media
+	if mode.Width > 0 {
+		fields += fmt.Sprintf(",width=%d", mode.Width)
+	}
+	if mode.Height > 0 {
+		fields += fmt.Sprintf(",height=%d", mode.Height)
+	}
+	if mode.Framerate != "" {
+		fields += ",framerate=" + mode.Framerate
+	}
+	for i := 0; i < caps.GetSize(); i++ {
+		st := caps.GetStructureAt(i)
+		if st == nil || st.Name() != media {
+			continue
+		}
+		// Carry over the structure's caps features (e.g. memory:NVMM) so
+		// they do not prevent the intersection.
+		candidateStr := fields
+		if features := caps.GetFeaturesAt(i); features != nil {
+			candidateStr = media + "(" + features.String() + ")" + strings.TrimPrefix(fields, media)
+		}
+		candidate := gst.NewCapsFromString(candidateStr)
+		if candidate != nil && caps.CopyNth(uint(i)).CanIntersect(candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractModes lists the fixed modes advertised for media in caps, sorted
+// by resolution and then by descending framerate, and keeps the first
+// limit. Raw video also lists the image/jpeg modes of MJPEG webcams, with
+// the mjpegFormat format.
+func extractModes(caps *gst.Caps, media string, limit int) []Mode {
 	if caps == nil || caps.IsEmpty() {
 		return nil
 	}
@@ -552,42 +930,84 @@ func extractModes(caps *gst.Caps, limit int) []Mode {
 	seen := make(map[string]struct{})
 	for i := 0; i < caps.GetSize(); i++ {
 		st := caps.GetStructureAt(i)
-		if st == nil || st.Name() != "video/x-raw" {
+		if st == nil {
 			continue
 		}
-		formats := extractStringValues(getStructureValue(st, "format"))
-		widths := extractIntValues(getStructureValue(st, "width"))
-		heights := extractIntValues(getStructureValue(st, "height"))
+		formats := []string{""}
+		switch {
+		case st.Name() == media && media == "video/x-raw":
+			formats = extractStringValues(getStructureValue(st, "format"))
+		case st.Name() == media:
+		case st.Name() == "image/jpeg" && media == "video/x-raw":
+			formats = []string{mjpegFormat}
+		default:
+			continue
+		}
+		resolutions := extractResolutions(getStructureValue(st, "width"), getStructureValue(st, "height"))
 		fps := extractFractionValues(getStructureValue(st, "framerate"))
-		if len(formats) == 0 || len(widths) == 0 || len(heights) == 0 || len(fps) == 0 {
+		if len(formats) == 0 || len(resolutions) == 0 || len(fps) == 0 {
 			continue
 		}
 		for _, format := range formats {
-			for _, w := range widths {
-				for _, h := range heights {
-					for _, fr := range fps {
-						key := fmt.Sprintf("%s|%d|%d|%s", format, w, h, fr)
-						if _, ok := seen[key]; ok {
-							continue
-						}
-						seen[key] = struct{}{}
-						modes = append(modes, Mode{
-							Format:    format,
-							Width:     w,
-							Height:    h,
-							Framerate: fr,
-						})
-						if len(modes) >= limit {
-							return modes
-						}
+			for _, res := range resolutions {
+				for _, fr := range fps {
+					key := fmt.Sprintf("%s|%d|%d|%s", format, res.Width, res.Height, fr)
+					if _, ok := seen[key]; ok {
+						continue
 					}
+					seen[key] = struct{}{}
+					modes = append(modes, Mode{
+						Format:    format,
+						Width:     res.Width,
+						Height:    res.Height,
+						Framerate: fr,
+					})
 				}
 			}
 		}
 	}
+	sort.SliceStable(modes, func(i, j int) bool {
+		a, b := modes[i], modes[j]
+		if a.Width != b.Width {
+			return a.Width < b.Width
+		}
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		if fa, fb := fractionValue(a.Framerate), fractionValue(b.Framerate); fa != fb {
+			return fa > fb
+		}
+		return a.Format < b.Format
+	})
+	if len(modes) > limit {
+		modes = modes[:limit]
+	}
 	return modes
 }
 
+// extractResolutions pairs the width and height values of a caps structure.
+// When either is a range too wide to enumerate, the common resolutions that
+// fit both fields are used instead.
+func extractResolutions(width, height any) []Mode {
+	widths := extractIntValues(width)
+	heights := extractIntValues(height)
+	var out []Mode
+	if len(widths) == 0 || len(heights) == 0 {
+		for _, res := range commonResolutions {
+			if intValueContains(width, res.Width) && intValueContains(height, res.Height) {
+				out = append(out, res)
+			}
+		}
+		return out
+	}
+	for _, w := range widths {
+		for _, h := range heights {
+			out = append(out, Mode{Width: w, Height: h})
+		}
+	}
+	return out
+}
+
 func getStructureValue(st *gst.Structure, key string) any {
 	if st == nil {
 		return nil
@@ -626,6 +1046,16 @@ func extractIntValues(val any) []int {
 		return []int{int(v)}
 	case uint64:
 		return []int{int(v)}
+	case *gst.IntRangeValue:
+		step := max(v.Step(), 1)
+		if v.End() < v.Start() || (v.End()-v.Start())/step >= maxRangeSteps {
+			return nil
+		}
+		var out []int
+		for n := v.Start(); n <= v.End(); n += step {
+			out = append(out, n)
+		}
+		return out
 	case *gst.ValueListValue:
 		return extractFromValueListInt(v, extractIntValues)
 	case *gst.ValueArrayValue:
@@ -635,6 +1065,33 @@ func extractIntValues(val any) []int {
 	}
 }
 
+// intValueContains reports whether n is one of the values described by val,
+// honouring the step of int ranges.
+func intValueContains(val any, n int) bool {
+	switch v := val.(type) {
+	case *gst.IntRangeValue:
+		step := max(v.Step(), 1)
+		return n >= v.Start() && n <= v.End() && (n-v.Start())%step == 0
+	case *gst.ValueListValue:
+		for i := uint(0); i < v.Size(); i++ {
+			if intValueContains(v.ValueAt(i), n) {
+				return true
+			}
+		}
+		return false
+	case *gst.ValueArrayValue:
+		for i := uint(0); i < v.Size(); i++ {
+			if intValueContains(v.ValueAt(i), n) {
+				return true
+			}
+		}
+		return false
+	default:
+		i, ok := toInt(val)
+		return ok && i == n
+	}
+}
+
 func extractFractionValues(val any) []string {
 	switch v := val.(type) {
 	case *gst.FractionValue:
@@ -643,6 +1100,8 @@ func extractFractionValues(val any) []string {
 		if _, err := parseFraction(v); err == nil {
 			return []string{v}
 		}
+	case *gst.FractionRangeValue:
+		return expandFractionRange(v)
 	case *gst.ValueListValue:
 		return extractFromValueList(v, extractFractionValues)
 	case *gst.ValueArrayValue:
@@ -653,6 +1112,31 @@ func extractFractionValues(val any) []string {
 	return nil
 }
 
+// expandFractionRange returns the common framerates inside the range, plus
+// its upper bound so the fastest rate is offered unless the range is
+// open-ended.
+func expandFractionRange(r *gst.FractionRangeValue) []string {
+	start, end := r.Start(), r.End()
+	if start == nil || end == nil || end.Denom() == 0 || start.Denom() == 0 {
+		return nil
+	}
+	lo := float64(start.Num()) / float64(start.Denom())
+	hi := float64(end.Num()) / float64(end.Denom())
+	if hi <= 0 || hi < lo {
+		return nil
+	}
+	var out []string
+	for _, fps := range commonFramerates {
+		if f := float64(fps); f >= lo && f < hi {
+			out = append(out, fmt.Sprintf("%d/1", fps))
+		}
+	}
+	if hi <= float64(commonFramerates[len(commonFramerates)-1]) {
+		out = append(out, end.String())
+	}
+	return out
+}
+
 func extractFromValueList(list *gst.ValueListValue, fn func(any) []string) []string {
 	if list == nil {
 		return nil
@@ -723,120 +1207,141 @@ func promptChoice(reader *bufio.Reader, prompt string, options []string) (int, e
 	}
 }
 
-func selectDevice(reader *bufio.Reader, className, capsStr, prompt string) (*gst.Device, error) {
+// selectDevice lists the devices of className and prompts for one, unless
+// name selects a device by display name or device property value. A
+// non-empty property (as built by buildDeviceProperty) must match as well.
+// extras are offered after the devices, even when none are found; picking
+// one returns a nil device and its index, which is -1 otherwise.
+func selectDevice(reader *bufio.Reader, className, capsStr, prompt, name, property string, extras []string) (*gst.Device, int, error) {
 	monitor := gst.NewDeviceMonitor()
 	filterCaps := gst.NewCapsFromString(capsStr)
 	monitor.AddFilter(className, filterCaps)
 	monitor.Start()
 	devices := monitor.GetDevices()
 	monitor.Stop()
-	if len(devices) == 0 {
-		return nil, fmt.Errorf("no devices found for %s", className)
+	if len(devices) == 0 && (name != "" || len(extras) == 0) {
+		return nil, -1, fmt.Errorf("no devices found for %s", className)
+	}
+	if name != "" {
+		for _, d := range devices {
+			if d.GetDisplayName() != name && deviceProperty(d).Value != name {
+				continue
+			}
+			if property != "" && buildDeviceProperty(d) != property {
+				continue
+			}
+			return d, -1, nil
+		}
+		if property != "" {
+			return nil, -1, fmt.Errorf("device %q (%s) is no longer present for %s", name, property, className)
+		}
+		return nil, -1, fmt.Errorf("device %q not found for %s", name, className)
 	}
 
-	deviceNames := make([]string, 0, len(devices))
+	deviceNames := make([]string, 0, len(devices)+len(extras))
 	for _, d := range devices {
 		deviceNames = append(deviceNames, d.GetDisplayName())
 	}
+	if len(devices) == 0 {
+		fmt.Printf("No devices found for %s.\n", className)
+	}
+	deviceNames = append(deviceNames, extras...)
 	idx, err := promptChoice(reader, prompt, deviceNames)
 	if err != nil {
-		return nil, err
+		return nil, -1, err
+	}
+	if idx >= len(devices) {
+		return nil, idx - len(devices), nil
 	}
-	return devices[idx], nil
+	return devices[idx], -1, nil
 }
 
-func promptCodec(reader *bufio.Reader, platform string, linuxVariant LinuxVariant) (Codec, error) {
+// promptCodec offers the codecs that have an encoder in the registry,
+// labelled with the encoders that will be used: the one for the Linux
+// H264/H265 mode if it was given, else those of every mode still on offer.
+func promptCodec(reader *bufio.Reader, platform string, linuxVariant LinuxVariant, linuxH264Mode LinuxH264Mode, linuxH265Mode LinuxH265Mode) (Codec, error) {
+	var codecs []Codec
 	var options []string
-	if platform == "linux" {
-		h264Label := "H264"
-		h265Label := "H265"
-		switch linuxVariant {
-		case LinuxJetson:
-			h264Label = "H264 (nvv4l2h264enc)"
-			h265Label = "H265 (nvv4l2h265enc)"
-		case LinuxRock5:
-			h264Label = "H264 (mpph264enc)"
-			h265Label = "H265 (mpph265enc)"
-		default:
-			h264Label = "H264 (vaapih264enc or variant-specific)"
-			h265Label = "H265 (vaapih265enc)"
-		}
-		options = []string{
-			h264Label,
-			h265Label,
-			"VP8 (vp8enc)",
-			"VP9 (vp9enc)",
-			"AV1 (svtav1enc)",
+	for _, c := range videoCodecs {
+		encoders := probeCodec(platform, linuxVariant, c)
+		if (c == CodecH264 && linuxH264Mode != "") || (c == CodecH265 && linuxH265Mode != "") {
+			encoders = nil
+			if encoder := probeEncoder(platform, linuxVariant, c, linuxH264Mode, linuxH265Mode); encoder != "" {
+				encoders = []string{encoder}
+			}
 		}
-	} else {
-		options = []string{
-			"H264 (vtenc_h264_hw)",
-			"H265 (vtenc_h265_hw)",
-			"VP8 (vp8enc)",
-			"VP9 (vp9enc)",
-			"AV1 (svtav1enc)",
+		if len(encoders) == 0 {
+			fmt.Printf("%s unavailable: no encoder found\n", c)
+			continue
 		}
+		codecs = append(codecs, c)
+		options = append(options, fmt.Sprintf("%s (%s)", c, strings.Join(encoders, ", ")))
+	}
+	if len(codecs) == 0 {
+		return CodecH264, errors.New("no video encoders available")
 	}
 	idx, err := promptChoice(reader, "Select a codec", options)
 	if err != nil {
 		return CodecH264, err
 	}
-	switch idx {
-	case 1:
-		return CodecH265, nil
-	case 2:
-		return CodecVP8, nil
-	case 3:
-		return CodecVP9, nil
-	case 4:
-		return CodecAV1, nil
-	default:
-		return CodecH264, nil
-	}
+	return codecs[idx], nil
 }
 
-func promptLinuxH264Mode(reader *bufio.Reader, variant LinuxVariant) (LinuxH264Mode, error) {
-	raspi := variant == LinuxRaspi
-	options := []string{
-		"VAAPI (vaapipostproc + vaapih264enc)",
-		"Raspberry Pi v4l2h264enc",
-		"libcamerasrc + v4l2h264enc",
-		"Camera H264 passthrough",
-	}
-	if raspi {
-		options = []string{
-			"Raspberry Pi v4l2h264enc",
-			"VAAPI (vaapipostproc + vaapih264enc)",
-			"libcamerasrc + v4l2h264enc",
-			"Camera H264 passthrough",
+// promptLinuxH264Mode offers the H264 modes that have an encoder in the
+// registry, labelled with it. The Raspberry Pi encoder comes first on a Pi.
+func promptLinuxH264Mode(reader *bufio.Reader, platform string, variant LinuxVariant) (LinuxH264Mode, error) {
+	labels := map[LinuxH264Mode]string{
+		LinuxH264VAAPI:      "VA-API, else software",
+		LinuxH264RaspiV4L2:  "Raspberry Pi V4L2",
+		LinuxH264Libcamera:  "libcamerasrc + V4L2",
+		LinuxH264CameraH264: "Camera H264 passthrough",
+		LinuxH264X264:       "Software x264 (no GPU)",
+		LinuxH264OpenH264:   "Software OpenH264 (no GPU)",
+	}
+	modes := append([]LinuxH264Mode{}, linuxH264Modes...)
+	if variant == LinuxRaspi {
+		modes[0], modes[1] = modes[1], modes[0]
+	}
+	var offered []LinuxH264Mode
+	var options []string
+	for _, m := range modes {
+		encoder := probeEncoder(platform, variant, CodecH264, m, LinuxH265VAAPI)
+		if encoder == "" {
+			fmt.Printf("%s unavailable: no encoder found\n", labels[m])
+			continue
 		}
+		offered = append(offered, m)
+		options = append(options, fmt.Sprintf("%s (%s)", labels[m], encoder))
 	}
 	idx, err := promptChoice(reader, "Select Linux H264 mode", options)
 	if err != nil {
 		return LinuxH264VAAPI, err
 	}
-	if raspi {
-		switch idx {
-		case 0:
-			return LinuxH264RaspiV4L2, nil
-		case 1:
-			return LinuxH264VAAPI, nil
-		case 2:
-			return LinuxH264Libcamera, nil
-		default:
-			return LinuxH264CameraH264, nil
+	return offered[idx], nil
+}
+
+// promptLinuxH265Mode is promptLinuxH264Mode for H265.
+func promptLinuxH265Mode(reader *bufio.Reader, platform string, variant LinuxVariant) (LinuxH265Mode, error) {
+	labels := map[LinuxH265Mode]string{
+		LinuxH265VAAPI: "VA-API, else software",
+		LinuxH265X265:  "Software x265 (no GPU)",
+	}
+	var offered []LinuxH265Mode
+	var options []string
+	for _, m := range linuxH265Modes {
+		encoder := probeEncoder(platform, variant, CodecH265, LinuxH264VAAPI, m)
+		if encoder == "" {
+			fmt.Printf("%s unavailable: no encoder found\n", labels[m])
+			continue
 		}
+		offered = append(offered, m)
+		options = append(options, fmt.Sprintf("%s (%s)", labels[m], encoder))
 	}
-	switch idx {
-	case 1:
-		return LinuxH264RaspiV4L2, nil
-	case 2:
-		return LinuxH264Libcamera, nil
-	case 3:
-		return LinuxH264CameraH264, nil
-	default:
-		return LinuxH264VAAPI, nil
+	idx, err := promptChoice(reader, "Select Linux H265 mode", options)
+	if err != nil {
+		return LinuxH265VAAPI, err
 	}
+	return offered[idx], nil
 }
 
 func promptAudioCodec(reader *bufio.Reader) (AudioCodec, error) {
@@ -876,10 +1381,16 @@ func promptFormat(reader *bufio.Reader) (string, error) {
 	}
 }
 
-func addPipelineWatch(pipeline *gst.Pipeline, label string, mainLoop *glib.MainLoop, all []*gst.Pipeline) {
+// addPipelineWatch stops every pipeline on an error or end-of-stream. With
+// loop set, end-of-stream instead rewinds the pipeline to replay its file.
+func addPipelineWatch(pipeline *gst.Pipeline, label string, mainLoop *glib.MainLoop, all []*gst.Pipeline, loop bool) {
 	pipeline.GetPipelineBus().AddWatch(func(msg *gst.Message) bool {
 		switch msg.Type() {
 		case gst.MessageEOS: // When end-of-stream is received stop the main loop
+			if loop && pipeline.SeekSimple(0, gst.FormatTime, gst.SeekFlagFlush|gst.SeekFlagKeyUnit) {
+				fmt.Printf("[%s] looping\n", label)
+				return true
+			}
 			for _, p := range all {
 				if p != nil {
 					p.BlockSetState(gst.StateNull)
@@ -907,11 +1418,21 @@ func addPipelineWatch(pipeline *gst.Pipeline, label string, mainLoop *glib.MainL
 	})
 }
 
-func devicePropPrefix(deviceProp string) string {
-	if deviceProp == "" {
-		return ""
+// flagOrEnv returns val, or the environment variable env if val is empty,
+// for secrets that are better kept off the command line.
+func flagOrEnv(val, env string) string {
+	if val == "" {
+		return os.Getenv(env)
+	}
+	return val
+}
+
+// orDefault returns v, or def if v is unset.
+func orDefault(v, def int) int {
+	if v == 0 {
+		return def
 	}
-	return deviceProp + " "
+	return v
 }
 
 func stringProp(values map[string]any, key string) string {
@@ -1010,7 +1531,7 @@ func promptPort(reader *bufio.Reader, prompt string, def int) (int, error) {
 		if err != nil {
 			return 0, err
 		}
-		if port < 1 || port > 65535 {
+		if err := validatePort(port); err != nil {
 			fmt.Println("Enter a port between 1 and 65535.")
 			continue
 		}
@@ -1018,6 +1539,13 @@ func promptPort(reader *bufio.Reader, prompt string, def int) (int, error) {
 	}
 }
 
+func validatePort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port %d is not between 1 and 65535", port)
+	}
+	return nil
+}
+
 func promptFraction(reader *bufio.Reader, prompt, def string) (string, error) {
 	for {
 		fmt.Printf("%s [%s]: ", prompt, def)
@@ -1053,6 +1581,23 @@ func parseFraction(val string) (string, error) {
 	return fmt.Sprintf("%d/%d", num, den), nil
 }
 
+// fractionValue returns a "num/den" string as a float, or 0 if it is invalid.
+func fractionValue(val string) float64 {
+	parts := strings.Split(val, "/")
+	if len(parts) != 2 {
+		return 0
+	}
+	num, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0
+	}
+	den, err := strconv.Atoi(parts[1])
+	if err != nil || den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
 func readLine(reader *bufio.Reader) (string, error) {
 	line, err := reader.ReadString('\n')
 	if err != nil && !errors.Is(err, io.EOF) {
@@ -1083,7 +1628,21 @@ func toInt(val any) (int, bool) {
 }
 
 func main() {
-	examples.RunLoop(func(loop *glib.MainLoop) error {
-		return runPipeline(loop)
-	})
+	mainLoop := glib.NewMainLoop(glib.MainContextDefault(), false)
+	var err error
+	args := os.Args[1:]
+	switch {
+	case len(args) > 0 && args[0] == "receive":
+		err = runReceive(mainLoop, args[1:])
+	case len(args) > 0 && args[0] == "selftest":
+		err = runSelftest(mainLoop, args[1:])
+	case len(args) > 0 && args[0] == "serve-rtsp":
+		err = runPipeline(mainLoop, append([]string{"--transport", string(TransportRTSP)}, args[1:]...))
+	default:
+		err = runPipeline(mainLoop, args)
+	}
+	if err != nil {
+		fmt.Println("ERROR!", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+func TestExtractModes(t *testing.T) {
+	gst.Init(nil)
+	tests := []struct {
+		name  string
+		caps  string
+		media string
+		limit int
+		want  []Mode
+	}{
+		{
+			name:  "scalars",
+			caps:  "video/x-raw,format=NV12,width=640,height=480,framerate=30/1",
+			media: "video/x-raw",
+			want:  []Mode{{"NV12", 640, 480, "30/1"}},
+		},
+		{
+			name:  "lists",
+			caps:  "video/x-raw,format={ NV12, YUY2 },width=1920,height=1080,framerate={ 30/1, 60/1 }",
+			media: "video/x-raw",
+			want: []Mode{
+				{"NV12", 1920, 1080, "60/1"},
+				{"YUY2", 1920, 1080, "60/1"},
+				{"NV12", 1920, 1080, "30/1"},
+				{"YUY2", 1920, 1080, "30/1"},
+			},
+		},
+		{
+			name:  "other media skipped",
+			caps:  "video/x-h264,width=1920,height=1080,framerate=30/1; video/x-raw,format=YUY2,width=640,height=480,framerate=30/1",
+			media: "video/x-raw",
+			want:  []Mode{{"YUY2", 640, 480, "30/1"}},
+		},
+		{
+			name:  "mjpeg",
+			caps:  "image/jpeg,width=1920,height=1080,framerate={ 30/1, 60/1 }; video/x-raw,format=YUY2,width=640,height=480,framerate=30/1",
+			media: "video/x-raw",
+			want: []Mode{
+				{"YUY2", 640, 480, "30/1"},
+				{"MJPEG", 1920, 1080, "60/1"},
+				{"MJPEG", 1920, 1080, "30/1"},
+			},
+		},
+		{
+			name:  "mjpeg only for raw video",
+			caps:  "image/jpeg,width=1920,height=1080,framerate=30/1",
+			media: "video/x-h264",
+			want:  []Mode{},
+		},
+		{
+			name:  "duplicates",
+			caps:  "video/x-raw,format=NV12,width=640,height=480,framerate=30/1; video/x-raw,format=NV12,width=640,height=480,framerate=30/1",
+			media: "video/x-raw",
+			want:  []Mode{{"NV12", 640, 480, "30/1"}},
+		},
+		{
+			name:  "limit keeps the first sorted modes",
+			caps:  "video/x-raw,format=NV12,width=1920,height=1080,framerate=30/1; image/jpeg,width=1280,height=720,framerate=30/1; video/x-raw,format=NV12,width=640,height=480,framerate={ 30/1, 60/1 }",
+			media: "video/x-raw",
+			limit: 2,
+			want: []Mode{
+				{"NV12", 640, 480, "60/1"},
+				{"NV12", 640, 480, "30/1"},
+			},
+		},
+		{
+			name:  "encoded media has no format",
+			caps:  "video/x-h264,stream-format=byte-stream,width=1280,height=720,framerate=30/1",
+			media: "video/x-h264",
+			want:  []Mode{{"", 1280, 720, "30/1"}},
+		},
+		{
+			name:  "wide int ranges",
+			caps:  "video/x-raw,format=NV12,width=[ 1, 4096 ],height=[ 1, 2160 ],framerate=30/1",
+			media: "video/x-raw",
+			want: []Mode{
+				{"NV12", 640, 480, "30/1"},
+				{"NV12", 1024, 768, "30/1"},
+				{"NV12", 1280, 720, "30/1"},
+				{"NV12", 1440, 1080, "30/1"},
+				{"NV12", 1920, 1080, "30/1"},
+				{"NV12", 3840, 2160, "30/1"},
+			},
+		},
+		{
+			name:  "narrow stepped ranges",
+			caps:  "video/x-raw,format=I420,width=[ 320, 640, 160 ],height=[ 240, 480, 240 ],framerate=15/1",
+			media: "video/x-raw",
+			want: []Mode{
+				{"I420", 320, 240, "15/1"},
+				{"I420", 320, 480, "15/1"},
+				{"I420", 480, 240, "15/1"},
+				{"I420", 480, 480, "15/1"},
+				{"I420", 640, 240, "15/1"},
+				{"I420", 640, 480, "15/1"},
+			},
+		},
+		{
+			// 1080 is not a multiple of 16 above 16, so 1440x1080 and
+			// 1920x1080 are left out.
+			name:  "wide stepped ranges",
+			caps:  "video/x-raw,format=NV12,width=[ 16, 4096, 16 ],height=[ 16, 2160, 16 ],framerate=30/1",
+			media: "video/x-raw",
+			want: []Mode{
+				{"NV12", 640, 480, "30/1"},
+				{"NV12", 1024, 768, "30/1"},
+				{"NV12", 1280, 720, "30/1"},
+				{"NV12", 3840, 2160, "30/1"},
+			},
+		},
+		{
+			name:  "fraction range",
+			caps:  "video/x-raw,format=YUY2,width=1280,height=720,framerate=[ 0/1, 60/1 ]",
+			media: "video/x-raw",
+			want: []Mode{
+				{"YUY2", 1280, 720, "60/1"},
+				{"YUY2", 1280, 720, "50/1"},
+				{"YUY2", 1280, 720, "30/1"},
+				{"YUY2", 1280, 720, "25/1"},
+				{"YUY2", 1280, 720, "24/1"},
+				{"YUY2", 1280, 720, "20/1"},
+				{"YUY2", 1280, 720, "15/1"},
+				{"YUY2", 1280, 720, "10/1"},
+				{"YUY2", 1280, 720, "5/1"},
+			},
+		},
+		{
+			name:  "open-ended fraction range",
+			caps:  "video/x-raw,format=NV12,width=640,height=480,framerate=[ 25/1, 2147483647/1 ]",
+			media: "video/x-raw",
+			want: []Mode{
+				{"NV12", 640, 480, "120/1"},
+				{"NV12", 640, 480, "90/1"},
+				{"NV12", 640, 480, "60/1"},
+				{"NV12", 640, 480, "50/1"},
+				{"NV12", 640, 480, "30/1"},
+				{"NV12", 640, 480, "25/1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caps := gst.NewCapsFromString(tt.caps)
+			if caps == nil {
+				t.Fatalf("invalid caps %q", tt.caps)
+			}
+			limit := tt.limit
+			if limit == 0 {
+				limit = 64
+			}
+			if got := extractModes(caps, tt.media, limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractModes(%q) = %v, want %v", tt.caps, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractIntValues(t *testing.T) {
+	tests := []struct {
+		name string
+		val  any
+		want []int
+	}{
+		{"scalar", 640, []int{640}},
+		{"range", gst.IntRange(1, 4, 1), []int{1, 2, 3, 4}},
+		{"stepped range", gst.IntRange(320, 640, 160), []int{320, 480, 640}},
+		{"zero step", gst.IntRange(8, 10, 0), []int{8, 9, 10}},
+		{"too wide", gst.IntRange(1, 4096, 1), nil},
+		{"empty", gst.IntRange(10, 1, 1), nil},
+		{"string", "640", nil},
+	}
+	for _, tt := range tests {
+		if got := extractIntValues(tt.val); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: extractIntValues(%v) = %v, want %v", tt.name, tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestIntValueContains(t *testing.T) {
+	tests := []struct {
+		val  any
+		n    int
+		want bool
+	}{
+		{640, 640, true},
+		{640, 480, false},
+		{gst.IntRange(1, 4096, 1), 1920, true},
+		{gst.IntRange(1, 4096, 1), 4097, false},
+		{gst.IntRange(16, 2160, 16), 720, true},
+		{gst.IntRange(16, 2160, 16), 1080, false},
+	}
+	for _, tt := range tests {
+		if got := intValueContains(tt.val, tt.n); got != tt.want {
+			t.Errorf("intValueContains(%v, %d) = %v, want %v", tt.val, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestExtractResolutions(t *testing.T) {
+	got := extractResolutions(gst.IntRange(1, 1280, 1), gst.IntRange(1, 800, 1))
+	want := []Mode{{Width: 640, Height: 480}, {Width: 1024, Height: 768}, {Width: 1280, Height: 720}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("extractResolutions = %v, want %v", got, want)
+	}
+	got = extractResolutions(gst.IntRange(640, 1280, 640), 720)
+	want = []Mode{{Width: 640, Height: 720}, {Width: 1280, Height: 720}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("extractResolutions = %v, want %v", got, want)
+	}
+}
+
+func TestExpandFractionRange(t *testing.T) {
+	tests := []struct {
+		start, end *gst.FractionValue
+		want       []string
+	}{
+		{gst.Fraction(0, 1), gst.Fraction(30, 1), []string{"5/1", "10/1", "15/1", "20/1", "24/1", "25/1", "30/1"}},
+		{gst.Fraction(30, 1), gst.Fraction(30, 1), []string{"30/1"}},
+		{gst.Fraction(15, 2), gst.Fraction(30000, 1001), []string{"10/1", "15/1", "20/1", "24/1", "25/1", "30000/1001"}},
+		{gst.Fraction(100, 1), gst.Fraction(1000, 1), []string{"120/1"}},
+		{gst.Fraction(60, 1), gst.Fraction(30, 1), nil},
+	}
+	for _, tt := range tests {
+		if got := expandFractionRange(gst.FractionRange(tt.start, tt.end)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandFractionRange(%s - %s) = %v, want %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// multicastConfig is how udpsink sends to a multicast group.
+type multicastConfig struct {
+	TTL   int
+	Iface string
+	// Loop delivers the stream to receivers on this host too, and AutoJoin
+	// makes udpsink join the group itself.
+	Loop     bool
+	AutoJoin bool
+}
+
+// sinkProps are the udpsink properties for m, which is nil for unicast.
+func (m *multicastConfig) sinkProps() []Property {
+	if m == nil {
+		return nil
+	}
+	return []Property{
+		prop("ttl-mc", m.TTL),
+		prop("loop", m.Loop),
+		prop("auto-multicast", m.AutoJoin),
+		optionalProp("multicast-iface", m.Iface),
+	}
+}
+
+// srcProps are the udpsrc properties that join group on m's interface.
+func (m *multicastConfig) srcProps(group string) []Property {
+	if m == nil {
+		return nil
+	}
+	return []Property{prop("address", group), optionalProp("multicast-iface", m.Iface)}
+}
+
+// multicastConfigFor is the multicast config of host, or nil if host is
+// not a multicast group.
+func multicastConfigFor(opts *Options, host string) *multicastConfig {
+	if !isMulticast(host) {
+		return nil
+	}
+	return &multicastConfig{
+		TTL:      opts.MulticastTTL,
+		Iface:    opts.MulticastIface,
+		Loop:     !opts.NoMulticastLoop,
+		AutoJoin: !opts.NoAutoMulticast,
+	}
+}
+
+func isMulticast(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsMulticast()
+}
+
+// validateMulticastGroup fails for multicast addresses a stream cannot be
+// sent to, or that need an interface to be usable.
+func validateMulticastGroup(host, iface string) error {
+	ip := net.ParseIP(host)
+	if ip.To4() != nil {
+		if ip.IsLinkLocalMulticast() {
+			return fmt.Errorf("%s is in 224.0.0.0/24, which is reserved for routing protocols (try 239.255.0.1)", host)
+		}
+		return nil
+	}
+	switch scope := ip[1] & 0x0f; {
+	case scope == 0 || scope == 0x0f:
+		return fmt.Errorf("%s has a reserved multicast scope", host)
+	case ip.IsInterfaceLocalMulticast():
+		return fmt.Errorf("%s is interface-local and never leaves this host", host)
+	case ip.IsLinkLocalMulticast() && iface == "":
+		return fmt.Errorf("%s is link-local and needs --multicast-iface", host)
+	}
+	return nil
+}
+
+// promptMulticast asks for the multicast TTL and interface if they were not
+// given, then validates them and the multicast hosts in opts.
+func promptMulticast(reader *bufio.Reader, opts *Options) error {
+	if opts.MulticastTTL == 0 {
+		for {
+			ttl, err := promptInt(reader, "Multicast TTL (1 stays on the local network)", 1)
+			if err != nil {
+				return err
+			}
+			if err := validateTTL(ttl); err != nil {
+				fmt.Println("Enter a TTL between 1 and 255.")
+				continue
+			}
+			opts.MulticastTTL = ttl
+			break
+		}
+	}
+	if opts.MulticastIface == "" {
+		iface, err := promptString(reader, "Multicast interface (- for the default route)", "-")
+		if err != nil {
+			return err
+		}
+		if iface != "-" {
+			opts.MulticastIface = iface
+		}
+	}
+	if opts.MulticastIface != "" {
+		if _, err := net.InterfaceByName(opts.MulticastIface); err != nil {
+			return fmt.Errorf("multicast-iface: %w", err)
+		}
+	}
+	for _, host := range []string{opts.VideoHost, opts.AudioHost} {
+		if !isMulticast(host) {
+			continue
+		}
+		if err := validateMulticastGroup(host, opts.MulticastIface); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTTL(ttl int) error {
+	if ttl < 1 || ttl > 255 {
+		return fmt.Errorf("TTL %d is not between 1 and 255", ttl)
+	}
+	return nil
+}
+
+// sdpConnection is the address of an SDP c= line, which carries the TTL
+// for IPv4 multicast groups.
+func sdpConnection(host string, ttl int) string {
+	if ip := net.ParseIP(host); ip != nil && ip.IsMulticast() && ip.To4() != nil {
+		return host + "/" + strconv.Itoa(ttl)
+	}
+	return host
+}
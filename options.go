@@ -0,0 +1,583 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Options holds every answer runPipeline needs. Zero values mean "not
+// supplied" and are filled in interactively. The JSON keys match the flag
+// names so a saved profile reads like the command line that produced it.
+type Options struct {
+	Transport           Transport     `json:"transport,omitempty"`
+	VideoHost           string        `json:"video-host,omitempty"`
+	VideoPort           int           `json:"video-port,omitempty"`
+	VideoRTCPPort       int           `json:"video-rtcp-port,omitempty"`
+	VideoRTCPRecvPort   int           `json:"video-rtcp-recv-port,omitempty"`
+	Codec               Codec         `json:"codec,omitempty"`
+	LinuxH264Mode       LinuxH264Mode `json:"h264-mode,omitempty"`
+	LinuxH265Mode       LinuxH265Mode `json:"h265-mode,omitempty"`
+	VideoSource         SourceKind    `json:"video-source,omitempty"`
+	VideoDevice         string        `json:"video-device,omitempty"`
+	VideoDeviceProperty string        `json:"video-device-property,omitempty"`
+	VideoPattern        string        `json:"video-pattern,omitempty"`
+	TimeOverlay         bool          `json:"timeoverlay,omitempty"`
+	File                string        `json:"file,omitempty"`
+	Loop                bool          `json:"loop,omitempty"`
+	Width               int           `json:"width,omitempty"`
+	Height              int           `json:"height,omitempty"`
+	Framerate           string        `json:"framerate,omitempty"`
+	Format              string        `json:"format,omitempty"`
+	AudioHost           string        `json:"audio-host,omitempty"`
+	AudioPort           int           `json:"audio-port,omitempty"`
+	AudioRTCPPort       int           `json:"audio-rtcp-port,omitempty"`
+	AudioRTCPRecvPort   int           `json:"audio-rtcp-recv-port,omitempty"`
+	AudioCodec          AudioCodec    `json:"audio-codec,omitempty"`
+	AudioSource         SourceKind    `json:"audio-source,omitempty"`
+	AudioDevice         string        `json:"audio-device,omitempty"`
+	AudioDeviceProperty string        `json:"audio-device-property,omitempty"`
+	AudioWave           string        `json:"audio-wave,omitempty"`
+	SDPFile             string        `json:"sdp,omitempty"`
+	SDPHTTP             string        `json:"sdp-http,omitempty"`
+	SDPHTTPKey          bool          `json:"sdp-http-key,omitempty"`
+	Sync                bool          `json:"sync,omitempty"`
+	RTX                 bool          `json:"rtx,omitempty"`
+	VideoRTXPT          int           `json:"video-rtx-pt,omitempty"`
+	AudioRTXPT          int           `json:"audio-rtx-pt,omitempty"`
+	RTXHistory          int           `json:"rtx-history,omitempty"`
+	FEC                 bool          `json:"fec,omitempty"`
+	FECOverhead         int           `json:"fec-overhead,omitempty"`
+	REDPT               int           `json:"red-pt,omitempty"`
+	FECPT               int           `json:"fec-pt,omitempty"`
+	SRTMode             SRTMode       `json:"srt-mode,omitempty"`
+	SRTLatency          int           `json:"srt-latency,omitempty"`
+	SRTPBKeyLen         int           `json:"srt-pbkeylen,omitempty"`
+	SRTStreamID         string        `json:"srt-streamid,omitempty"`
+	RTSPMount           string        `json:"rtsp-mount,omitempty"`
+	RTSPUser            string        `json:"rtsp-user,omitempty"`
+	RTSPTCP             bool          `json:"rtsp-tcp,omitempty"`
+	WHIPURL             string        `json:"whip-url,omitempty"`
+	RTMPURL             string        `json:"rtmp-url,omitempty"`
+	MulticastTTL        int           `json:"ttl-mc,omitempty"`
+	MulticastIface      string        `json:"multicast-iface,omitempty"`
+	NoMulticastLoop     bool          `json:"no-multicast-loop,omitempty"`
+	NoAutoMulticast     bool          `json:"no-auto-multicast,omitempty"`
+	SRTP                bool          `json:"srtp,omitempty"`
+	SRTPKeyFile         string        `json:"srtp-key-file,omitempty"`
+	SRTPRotate          bool          `json:"srtp-rotate,omitempty"`
+	SRTPCipher          SRTPCipher    `json:"srtp-cipher,omitempty"`
+	SRTPAuth            SRTPAuth      `json:"srtp-auth,omitempty"`
+
+	// Secrets are never saved to a profile.
+	SRTPKey       string `json:"-"`
+	SRTPassphrase string `json:"-"`
+	RTSPPassword  string `json:"-"`
+	WHIPToken     string `json:"-"`
+	RTMPKey       string `json:"-"`
+	Profile       string `json:"-"`
+	SaveProfile   string `json:"-"`
+	DryRun        bool   `json:"-"`
+}
+
+func parseOptions(args []string) (*Options, error) {
+	opts := &Options{}
+	var codec, h264Mode, h265Mode, audioCodec, videoSource, audioSource, transport, srtMode, srtpCipher, srtpAuth string
+
+	fs := flag.NewFlagSet("cli", flag.ContinueOnError)
+	fs.StringVar(&transport, "transport", "", "how to send the streams (rtp, mpegts, srt, rtsp, whip, rtmp)")
+	fs.StringVar(&opts.VideoHost, "video-host", "", "video UDP host")
+	fs.IntVar(&opts.VideoPort, "video-port", 0, "video UDP port")
+	fs.IntVar(&opts.VideoRTCPPort, "video-rtcp-port", 0, "port on the video host for RTCP sender reports (default video-port+1, or the next free port)")
+	fs.IntVar(&opts.VideoRTCPRecvPort, "video-rtcp-recv-port", 0, "local port for video RTCP receiver reports (default video-rtcp-port, +4 on a loopback host)")
+	fs.StringVar(&codec, "codec", "", "video codec (H264, H265, VP8, VP9, AV1)")
+	fs.StringVar(&h264Mode, "h264-mode", "", "Linux H264 mode (vaapi, raspi-v4l2, libcamera, camera-h264, x264, openh264)")
+	fs.StringVar(&h265Mode, "h265-mode", "", "Linux H265 mode (vaapi, x265)")
+	fs.StringVar(&videoSource, "video-source", "", "video source (device, test, file)")
+	fs.StringVar(&opts.VideoDevice, "video-device", "", "camera display name or device path")
+	fs.StringVar(&opts.VideoPattern, "video-pattern", "", "videotestsrc pattern for the test source (e.g. smpte, ball, snow)")
+	fs.BoolVar(&opts.TimeOverlay, "timeoverlay", false, "overlay the running time on the test pattern")
+	fs.StringVar(&opts.File, "file", "", "media file for the file source (MP4, MKV, TS, ...)")
+	fs.BoolVar(&opts.Loop, "loop", false, "replay the file source when it ends")
+	fs.IntVar(&opts.Width, "width", 0, "video width")
+	fs.IntVar(&opts.Height, "height", 0, "video height")
+	fs.StringVar(&opts.Framerate, "framerate", "", "video framerate (num/den)")
+	fs.StringVar(&opts.Format, "format", "", "raw video format (e.g. NV12, I420), or MJPEG to capture and decode JPEG")
+	fs.StringVar(&opts.AudioHost, "audio-host", "", "audio UDP host (defaults to the video host)")
+	fs.IntVar(&opts.AudioPort, "audio-port", 0, "audio UDP port")
+	fs.IntVar(&opts.AudioRTCPPort, "audio-rtcp-port", 0, "port on the audio host for RTCP sender reports (default audio-port+1, or the next free port)")
+	fs.IntVar(&opts.AudioRTCPRecvPort, "audio-rtcp-recv-port", 0, "local port for audio RTCP receiver reports (default audio-rtcp-port, +4 on a loopback host)")
+	fs.StringVar(&audioCodec, "audio-codec", "", "audio codec (OPUS, PCMU; AAC with --transport rtmp)")
+	fs.StringVar(&audioSource, "audio-source", "", "audio source (device, test, file)")
+	fs.StringVar(&opts.AudioDevice, "audio-device", "", "audio device display name or identifier")
+	fs.StringVar(&opts.AudioWave, "audio-wave", "", "audiotestsrc wave for the test source (e.g. sine, ticks)")
+	fs.StringVar(&opts.SDPFile, "sdp", "", "write an SDP describing both streams to this file (- for stdout)")
+	fs.StringVar(&opts.SDPHTTP, "sdp-http", "", "serve the SDP over HTTP on this address (e.g. :8000)")
+	fs.BoolVar(&opts.SDPHTTPKey, "sdp-http-key", false, "allow --sdp-http with --srtp, serving the SRTP key to anyone who can reach it")
+	fs.BoolVar(&opts.Sync, "sync", false, "send both streams from one pipeline so receivers can lip-sync them")
+	fs.BoolVar(&opts.RTX, "rtx", false, "retransmit packets the receiver reports lost (RFC 4588)")
+	fs.IntVar(&opts.VideoRTXPT, "video-rtx-pt", 0, "payload type for video retransmissions with --rtx (default 97)")
+	fs.IntVar(&opts.AudioRTXPT, "audio-rtx-pt", 0, "payload type for audio retransmissions with --rtx (default 97)")
+	fs.IntVar(&opts.RTXHistory, "rtx-history", 0, "milliseconds of sent packets kept for retransmission with --rtx (default 1000)")
+	fs.BoolVar(&opts.FEC, "fec", false, "protect the video with ULPFEC packets wrapped in RED (RFC 5109, RFC 2198)")
+	fs.IntVar(&opts.FECOverhead, "fec-overhead", 0, "FEC packets as a percentage of media packets with --fec (default 20)")
+	fs.IntVar(&opts.REDPT, "red-pt", 0, "payload type of the RED packets with --fec (default 98)")
+	fs.IntVar(&opts.FECPT, "fec-pt", 0, "payload type of the ULPFEC packets with --fec (default 99)")
+	fs.StringVar(&srtMode, "srt-mode", "", "SRT mode with --transport srt (caller, listener; default caller with --video-host)")
+	fs.IntVar(&opts.SRTLatency, "srt-latency", 0, "SRT latency in milliseconds (default 120)")
+	fs.StringVar(&opts.SRTPassphrase, "srt-passphrase", "", "SRT passphrase, 10 to 79 characters (default $"+srtPassphraseEnv+")")
+	fs.IntVar(&opts.SRTPBKeyLen, "srt-pbkeylen", 0, "SRT AES key length in bytes (16, 24, 32; default 16)")
+	fs.StringVar(&opts.SRTStreamID, "srt-streamid", "", "SRT stream ID a caller sends to the listener")
+	fs.StringVar(&opts.RTSPMount, "rtsp-mount", "", "mount point to serve with --transport rtsp (default /stream)")
+	fs.StringVar(&opts.RTSPUser, "rtsp-user", "", "user RTSP clients must log in as with basic auth")
+	fs.StringVar(&opts.RTSPPassword, "rtsp-password", "", "password for --rtsp-user (default $"+rtspPasswordEnv+")")
+	fs.BoolVar(&opts.RTSPTCP, "rtsp-tcp", false, "only serve RTP interleaved in the RTSP connection")
+	fs.StringVar(&opts.WHIPURL, "whip-url", "", "WHIP endpoint to publish to with --transport whip")
+	fs.StringVar(&opts.WHIPToken, "whip-token", "", "bearer token for the WHIP endpoint (default $"+whipTokenEnv+")")
+	fs.StringVar(&opts.RTMPURL, "rtmp-url", "", "RTMP ingest URL to publish to with --transport rtmp")
+	fs.StringVar(&opts.RTMPKey, "rtmp-key", "", "RTMP stream key, appended to --rtmp-url (default $"+rtmpKeyEnv+")")
+	fs.IntVar(&opts.MulticastTTL, "ttl-mc", 0, "TTL for multicast hosts (default 1)")
+	fs.StringVar(&opts.MulticastIface, "multicast-iface", "", "network interface to send multicast from")
+	fs.BoolVar(&opts.NoMulticastLoop, "no-multicast-loop", false, "do not deliver multicast to receivers on this host")
+	fs.BoolVar(&opts.NoAutoMulticast, "no-auto-multicast", false, "do not join the multicast group when sending to it")
+	fs.BoolVar(&opts.SRTP, "srtp", false, "encrypt RTP and RTCP with SRTP")
+	fs.StringVar(&opts.SRTPKey, "srtp-key", "", "SRTP master key and salt in hex (default $"+srtpKeyEnv+", then --srtp-key-file)")
+	fs.StringVar(&opts.SRTPKeyFile, "srtp-key-file", "", "file holding the SRTP key in hex")
+	fs.BoolVar(&opts.SRTPRotate, "srtp-rotate", false, "write a new random SRTP key to --srtp-key-file on every start")
+	fs.StringVar(&srtpCipher, "srtp-cipher", "", "SRTP cipher (aes-128-icm, aes-256-icm, aes-128-gcm, aes-256-gcm; default aes-128-icm)")
+	fs.StringVar(&srtpAuth, "srtp-auth", "", "SRTP authentication for the icm ciphers (hmac-sha1-80, hmac-sha1-32; default hmac-sha1-80)")
+	fs.StringVar(&opts.Profile, "profile", "", "load answers from a JSON profile; flags override it")
+	fs.StringVar(&opts.SaveProfile, "save-profile", "", "save the final answers to a JSON profile")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "print the gst-launch-1.0 commands instead of running them")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	opts.Transport = Transport(transport)
+	opts.SRTMode = SRTMode(srtMode)
+	opts.Codec = Codec(codec)
+	opts.LinuxH264Mode = LinuxH264Mode(h264Mode)
+	opts.LinuxH265Mode = LinuxH265Mode(h265Mode)
+	opts.AudioCodec = AudioCodec(audioCodec)
+	opts.VideoSource = SourceKind(videoSource)
+	opts.AudioSource = SourceKind(audioSource)
+	opts.SRTPCipher = SRTPCipher(srtpCipher)
+	opts.SRTPAuth = SRTPAuth(srtpAuth)
+
+	if err := rejectZeroFlags(fs); err != nil {
+		return nil, err
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	if opts.Profile == "" {
+		return opts, nil
+	}
+	profile, err := loadProfile(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	profile.merge(opts)
+	return profile, nil
+}
+
+// rejectZeroFlags fails on an int flag given as 0, since zero means "prompt
+// for it".
+func rejectZeroFlags(fs *flag.FlagSet) error {
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if g, ok := f.Value.(flag.Getter); ok && g.Get() == 0 && err == nil {
+			err = fmt.Errorf("--%s: must not be 0", f.Name)
+		}
+	})
+	return err
+}
+
+// validate checks and normalizes every field that has been set.
+func (o *Options) validate() error {
+	var err error
+	if o.VideoPort != 0 {
+		if err := validatePort(o.VideoPort); err != nil {
+			return fmt.Errorf("video-port: %w", err)
+		}
+	}
+	if o.AudioPort != 0 {
+		if err := validatePort(o.AudioPort); err != nil {
+			return fmt.Errorf("audio-port: %w", err)
+		}
+	}
+	if o.VideoRTCPPort != 0 {
+		if err := validatePort(o.VideoRTCPPort); err != nil {
+			return fmt.Errorf("video-rtcp-port: %w", err)
+		}
+	}
+	if o.VideoRTCPRecvPort != 0 {
+		if err := validatePort(o.VideoRTCPRecvPort); err != nil {
+			return fmt.Errorf("video-rtcp-recv-port: %w", err)
+		}
+	}
+	if o.AudioRTCPPort != 0 {
+		if err := validatePort(o.AudioRTCPPort); err != nil {
+			return fmt.Errorf("audio-rtcp-port: %w", err)
+		}
+	}
+	if o.AudioRTCPRecvPort != 0 {
+		if err := validatePort(o.AudioRTCPRecvPort); err != nil {
+			return fmt.Errorf("audio-rtcp-recv-port: %w", err)
+		}
+	}
+	if o.VideoRTXPT != 0 {
+		if err := validateDynamicPT(o.VideoRTXPT); err != nil {
+			return fmt.Errorf("video-rtx-pt: %w", err)
+		}
+	}
+	if o.AudioRTXPT != 0 {
+		if err := validateDynamicPT(o.AudioRTXPT); err != nil {
+			return fmt.Errorf("audio-rtx-pt: %w", err)
+		}
+	}
+	if o.RTXHistory < 0 {
+		return errors.New("rtx-history: must be a positive number")
+	}
+	if o.FECOverhead < 0 || o.FECOverhead > 100 {
+		return fmt.Errorf("fec-overhead: %d is not between 1 and 100 (default 20)", o.FECOverhead)
+	}
+	if o.MulticastTTL != 0 {
+		if err := validateTTL(o.MulticastTTL); err != nil {
+			return fmt.Errorf("ttl-mc: %w", err)
+		}
+	}
+	if o.REDPT != 0 {
+		if err := validateDynamicPT(o.REDPT); err != nil {
+			return fmt.Errorf("red-pt: %w", err)
+		}
+	}
+	if o.FECPT != 0 {
+		if err := validateDynamicPT(o.FECPT); err != nil {
+			return fmt.Errorf("fec-pt: %w", err)
+		}
+	}
+	if o.Width < 0 {
+		return errors.New("width: must be a positive number")
+	}
+	if o.Height < 0 {
+		return errors.New("height: must be a positive number")
+	}
+	if (o.Width > 0) != (o.Height > 0) {
+		return errors.New("width and height must be given together")
+	}
+	if o.Framerate != "" {
+		fps, err := parseFraction(o.Framerate)
+		if err != nil {
+			return fmt.Errorf("framerate: %q is not a fraction like 30/1", o.Framerate)
+		}
+		o.Framerate = fps
+	}
+	if o.Transport != "" {
+		if o.Transport, err = parseTransport(string(o.Transport)); err != nil {
+			return fmt.Errorf("transport: %w", err)
+		}
+	}
+	if o.SRTMode != "" {
+		if o.SRTMode, err = parseSRTMode(string(o.SRTMode)); err != nil {
+			return fmt.Errorf("srt-mode: %w", err)
+		}
+	}
+	if o.RTSPMount != "" {
+		if err := validateRTSPMount(o.RTSPMount); err != nil {
+			return fmt.Errorf("rtsp-mount: %w", err)
+		}
+	}
+	if o.WHIPURL != "" {
+		if err := validateWHIPURL(o.WHIPURL); err != nil {
+			return fmt.Errorf("whip-url: %w", err)
+		}
+	}
+	if o.RTMPURL != "" {
+		if err := validateRTMPURL(o.RTMPURL); err != nil {
+			return fmt.Errorf("rtmp-url: %w", err)
+		}
+	}
+	if o.Codec != "" {
+		if o.Codec, err = parseCodec(string(o.Codec)); err != nil {
+			return fmt.Errorf("codec: %w", err)
+		}
+	}
+	if o.LinuxH264Mode != "" {
+		if o.LinuxH264Mode, err = parseLinuxH264Mode(string(o.LinuxH264Mode)); err != nil {
+			return fmt.Errorf("h264-mode: %w", err)
+		}
+	}
+	if o.LinuxH265Mode != "" {
+		if o.LinuxH265Mode, err = parseLinuxH265Mode(string(o.LinuxH265Mode)); err != nil {
+			return fmt.Errorf("h265-mode: %w", err)
+		}
+	}
+	if o.AudioCodec != "" {
+		if o.AudioCodec, err = parseAudioCodec(string(o.AudioCodec)); err != nil {
+			return fmt.Errorf("audio-codec: %w", err)
+		}
+	}
+	if o.VideoSource != "" {
+		if o.VideoSource, err = parseSourceKind(string(o.VideoSource)); err != nil {
+			return fmt.Errorf("video-source: %w", err)
+		}
+	}
+	if o.AudioSource != "" {
+		if o.AudioSource, err = parseSourceKind(string(o.AudioSource)); err != nil {
+			return fmt.Errorf("audio-source: %w", err)
+		}
+	}
+	if o.SRTPCipher != "" {
+		if o.SRTPCipher, err = parseSRTPCipher(string(o.SRTPCipher)); err != nil {
+			return fmt.Errorf("srtp-cipher: %w", err)
+		}
+	}
+	if o.SRTPAuth != "" {
+		if o.SRTPAuth, err = parseSRTPAuth(string(o.SRTPAuth)); err != nil {
+			return fmt.Errorf("srtp-auth: %w", err)
+		}
+	}
+	if o.VideoSource != "" && o.VideoSource != SourceDevice && o.VideoDevice != "" {
+		return fmt.Errorf("video-device cannot be used with the %s source", o.VideoSource)
+	}
+	if o.AudioSource != "" && o.AudioSource != SourceDevice && o.AudioDevice != "" {
+		return fmt.Errorf("audio-device cannot be used with the %s source", o.AudioSource)
+	}
+	return nil
+}
+
+// merge overwrites o with every field that is set in over.
+func (o *Options) merge(over *Options) {
+	if over.Transport != "" {
+		o.Transport = over.Transport
+	}
+	if over.VideoHost != "" {
+		o.VideoHost = over.VideoHost
+	}
+	if over.VideoPort != 0 {
+		o.VideoPort = over.VideoPort
+	}
+	if over.VideoRTCPPort != 0 {
+		o.VideoRTCPPort = over.VideoRTCPPort
+	}
+	if over.VideoRTCPRecvPort != 0 {
+		o.VideoRTCPRecvPort = over.VideoRTCPRecvPort
+	}
+	if over.Codec != "" {
+		o.Codec = over.Codec
+	}
+	if over.LinuxH264Mode != "" {
+		o.LinuxH264Mode = over.LinuxH264Mode
+	}
+	if over.LinuxH265Mode != "" {
+		o.LinuxH265Mode = over.LinuxH265Mode
+	}
+	if over.VideoSource != "" || over.VideoDevice != "" {
+		o.VideoSource = over.VideoSource
+		o.VideoDevice = over.VideoDevice
+		o.VideoDeviceProperty = over.VideoDeviceProperty
+	}
+	if over.VideoPattern != "" {
+		o.VideoPattern = over.VideoPattern
+	}
+	if over.TimeOverlay {
+		o.TimeOverlay = true
+	}
+	if over.File != "" {
+		o.File = over.File
+	}
+	if over.Loop {
+		o.Loop = true
+	}
+	if over.Width != 0 {
+		o.Width = over.Width
+		o.Height = over.Height
+	}
+	if over.Framerate != "" {
+		o.Framerate = over.Framerate
+	}
+	if over.Format != "" {
+		o.Format = over.Format
+	}
+	if over.AudioHost != "" {
+		o.AudioHost = over.AudioHost
+	}
+	if over.AudioPort != 0 {
+		o.AudioPort = over.AudioPort
+	}
+	if over.AudioRTCPPort != 0 {
+		o.AudioRTCPPort = over.AudioRTCPPort
+	}
+	if over.AudioRTCPRecvPort != 0 {
+		o.AudioRTCPRecvPort = over.AudioRTCPRecvPort
+	}
+	if over.AudioCodec != "" {
+		o.AudioCodec = over.AudioCodec
+	}
+	if over.AudioSource != "" || over.AudioDevice != "" {
+		o.AudioSource = over.AudioSource
+		o.AudioDevice = over.AudioDevice
+		o.AudioDeviceProperty = over.AudioDeviceProperty
+	}
+	if over.AudioWave != "" {
+		o.AudioWave = over.AudioWave
+	}
+	if over.SDPFile != "" {
+		o.SDPFile = over.SDPFile
+	}
+	if over.SDPHTTP != "" {
+		o.SDPHTTP = over.SDPHTTP
+	}
+	if over.SDPHTTPKey {
+		o.SDPHTTPKey = true
+	}
+	if over.Sync {
+		o.Sync = true
+	}
+	if over.RTX {
+		o.RTX = true
+	}
+	if over.VideoRTXPT != 0 {
+		o.VideoRTXPT = over.VideoRTXPT
+	}
+	if over.AudioRTXPT != 0 {
+		o.AudioRTXPT = over.AudioRTXPT
+	}
+	if over.RTXHistory != 0 {
+		o.RTXHistory = over.RTXHistory
+	}
+	if over.FEC {
+		o.FEC = true
+	}
+	if over.FECOverhead != 0 {
+		o.FECOverhead = over.FECOverhead
+	}
+	if over.REDPT != 0 {
+		o.REDPT = over.REDPT
+	}
+	if over.FECPT != 0 {
+		o.FECPT = over.FECPT
+	}
+	if over.SRTMode != "" {
+		o.SRTMode = over.SRTMode
+	}
+	if over.SRTLatency != 0 {
+		o.SRTLatency = over.SRTLatency
+	}
+	if over.SRTPassphrase != "" {
+		o.SRTPassphrase = over.SRTPassphrase
+	}
+	if over.SRTPBKeyLen != 0 {
+		o.SRTPBKeyLen = over.SRTPBKeyLen
+	}
+	if over.SRTStreamID != "" {
+		o.SRTStreamID = over.SRTStreamID
+	}
+	if over.RTSPMount != "" {
+		o.RTSPMount = over.RTSPMount
+	}
+	if over.RTSPUser != "" {
+		o.RTSPUser = over.RTSPUser
+	}
+	if over.RTSPPassword != "" {
+		o.RTSPPassword = over.RTSPPassword
+	}
+	if over.RTSPTCP {
+		o.RTSPTCP = true
+	}
+	if over.WHIPURL != "" {
+		o.WHIPURL = over.WHIPURL
+	}
+	if over.WHIPToken != "" {
+		o.WHIPToken = over.WHIPToken
+	}
+	if over.RTMPURL != "" {
+		o.RTMPURL = over.RTMPURL
+	}
+	if over.RTMPKey != "" {
+		o.RTMPKey = over.RTMPKey
+	}
+	if over.MulticastTTL != 0 {
+		o.MulticastTTL = over.MulticastTTL
+	}
+	if over.MulticastIface != "" {
+		o.MulticastIface = over.MulticastIface
+	}
+	if over.NoMulticastLoop {
+		o.NoMulticastLoop = true
+	}
+	if over.NoAutoMulticast {
+		o.NoAutoMulticast = true
+	}
+	if over.SRTP {
+		o.SRTP = true
+	}
+	if over.SRTPKey != "" {
+		o.SRTPKey = over.SRTPKey
+	}
+	if over.SRTPKeyFile != "" {
+		o.SRTPKeyFile = over.SRTPKeyFile
+	}
+	if over.SRTPRotate {
+		o.SRTPRotate = true
+	}
+	if over.SRTPCipher != "" {
+		o.SRTPCipher = over.SRTPCipher
+	}
+	if over.SRTPAuth != "" {
+		o.SRTPAuth = over.SRTPAuth
+	}
+	o.Profile = over.Profile
+	o.SaveProfile = over.SaveProfile
+	o.DryRun = over.DryRun
+}
+
+// validateDynamicPT checks that pt is a dynamic RTP payload type other
+// than 96, which the payloaders use.
+func validateDynamicPT(pt int) error {
+	if pt < 97 || pt > 127 {
+		return fmt.Errorf("payload type %d is not between 97 and 127", pt)
+	}
+	return nil
+}
+
+func parseCodec(val string) (Codec, error) {
+	for _, c := range videoCodecs {
+		if strings.EqualFold(val, string(c)) {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown codec %q", val)
+}
+
+func parseLinuxH264Mode(val string) (LinuxH264Mode, error) {
+	for _, m := range linuxH264Modes {
+		if strings.EqualFold(val, string(m)) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown H264 mode %q", val)
+}
+
+func parseLinuxH265Mode(val string) (LinuxH265Mode, error) {
+	for _, m := range linuxH265Modes {
+		if strings.EqualFold(val, string(m)) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown H265 mode %q", val)
+}
+
+func parseAudioCodec(val string) (AudioCodec, error) {
+	for _, c := range []AudioCodec{AudioOpus, AudioPCMU, AudioAAC} {
+		if strings.EqualFold(val, string(c)) {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown audio codec %q", val)
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// Property is an element property with its value in gst-launch syntax.
+// Shown, if set, is printed instead of a Value that holds a secret.
+type Property struct {
+	Name  string
+	Value string
+	Shown string
+}
+
+func prop(name string, value any) Property {
+	return Property{Name: name, Value: fmt.Sprint(value)}
+}
+
+// secretProp is a property printed, in dry runs and errors, as shown.
+func secretProp(name string, value any, shown string) Property {
+	return Property{Name: name, Value: fmt.Sprint(value), Shown: shown}
+}
+
+// ElementDesc is one element of a PipelineDesc. An element without a
+// Factory is a caps filter for Caps, and one with only a Pad refers to a pad
+// of a named element, as "rtpbin.send_rtp_sink_0" does in gst-launch syntax.
+type ElementDesc struct {
+	Factory string
+	Props   []Property
+	Caps    string
+	Pad     string
+	// Setup runs once Build has created the element, before any linking.
+	Setup func(*gst.Element) error
+}
+
+// PipelineDesc is an ordered chain of elements, linked source to sink, plus
+// any further chains in the same pipeline. It can be rendered as a
+// gst-launch string or instantiated with Build.
+type PipelineDesc struct {
+	Elements []ElementDesc
+	Branches []*PipelineDesc
+}
+
+// Add appends an element. Properties without a name are skipped so an
+// optional device property can be passed unconditionally.
+func (p *PipelineDesc) Add(factory string, props ...Property) *PipelineDesc {
+	elem := ElementDesc{Factory: factory}
+	for _, pr := range props {
+		if pr.Name != "" {
+			elem.Props = append(elem.Props, pr)
+		}
+	}
+	p.Elements = append(p.Elements, elem)
+	return p
+}
+
+// Caps appends a caps filter.
+func (p *PipelineDesc) Caps(caps string) *PipelineDesc {
+	p.Elements = append(p.Elements, ElementDesc{Caps: caps})
+	return p
+}
+
+// Setup registers fn to run on the last element once Build creates it,
+// e.g. to connect signals that fire while the pipeline is linked. It is not
+// part of the gst-launch rendering.
+func (p *PipelineDesc) Setup(fn func(*gst.Element) error) *PipelineDesc {
+	p.Elements[len(p.Elements)-1].Setup = fn
+	return p
+}
+
+// Pad appends a reference to a pad of a named element. An empty pad name,
+// as in "rtpbin.", links whichever pad fits.
+func (p *PipelineDesc) Pad(ref string) *PipelineDesc {
+	p.Elements = append(p.Elements, ElementDesc{Pad: ref})
+	return p
+}
+
+// Branch starts another chain in the same pipeline and returns it.
+func (p *PipelineDesc) Branch() *PipelineDesc {
+	b := &PipelineDesc{}
+	p.Branches = append(p.Branches, b)
+	return b
+}
+
+// chains returns p and every branch under it, in order.
+func (p *PipelineDesc) chains() []*PipelineDesc {
+	chains := []*PipelineDesc{p}
+	for _, b := range p.Branches {
+		chains = append(chains, b.chains()...)
+	}
+	return chains
+}
+
+// LeakyQueue appends a queue that drops old buffers once maxBuffers are queued.
+func (p *PipelineDesc) LeakyQueue(maxBuffers int) *PipelineDesc {
+	return p.Add("queue", prop("max-size-buffers", maxBuffers), prop("leaky", "downstream"))
+}
+
+// UDPSink appends the udpsink every sender pipeline ends with, with extra
+// properties such as the multicast ones.
+func (p *PipelineDesc) UDPSink(host string, port int, extra ...Property) *PipelineDesc {
+	props := []Property{prop("host", host), prop("port", port), prop("sync", false), prop("async", false)}
+	return p.Add("udpsink", append(props, extra...)...)
+}
+
+// UDPSrc appends the udpsrc every receiver pipeline starts with, with extra
+// properties such as the multicast ones.
+func (p *PipelineDesc) UDPSrc(port int, caps string, extra ...Property) *PipelineDesc {
+	props := []Property{prop("port", port), prop("caps", caps)}
+	return p.Add("udpsrc", append(props, extra...)...)
+}
+
+// String renders the pipeline in gst-launch syntax.
+func (p *PipelineDesc) String() string {
+	var chains []string
+	for _, c := range p.chains() {
+		parts := make([]string, 0, len(c.Elements))
+		for _, e := range c.Elements {
+			parts = append(parts, e.String())
+		}
+		chains = append(chains, strings.Join(parts, " ! "))
+	}
+	return strings.Join(chains, " ")
+}
+
+func (e ElementDesc) String() string {
+	if e.Pad != "" {
+		return e.Pad
+	}
+	if e.Factory == "" {
+		return e.Caps
+	}
+	var sb strings.Builder
+	sb.WriteString(e.Factory)
+	for _, pr := range e.Props {
+		sb.WriteString(" ")
+		sb.WriteString(pr.String())
+	}
+	return sb.String()
+}
+
+func (pr Property) String() string {
+	value := pr.Value
+	if pr.Shown != "" {
+		value = pr.Shown
+	}
+	if value == "" || strings.ContainsAny(value, " \t\"'!,=()[]{}<>;") {
+		value = strconv.Quote(value)
+	}
+	return pr.Name + "=" + value
+}
+
+// LaunchCommand renders the pipeline as a gst-launch-1.0 command line with
+// every argument quoted for a POSIX shell.
+func (p *PipelineDesc) LaunchCommand() string {
+	args := []string{"gst-launch-1.0", "-v", "-e"}
+	for _, c := range p.chains() {
+		for i, e := range c.Elements {
+			if i > 0 {
+				args = append(args, "!")
+			}
+			switch {
+			case e.Pad != "":
+				args = append(args, shellQuote(e.Pad))
+			case e.Factory == "":
+				args = append(args, shellQuote(e.Caps))
+			default:
+				args = append(args, shellQuote(e.Factory))
+				for _, pr := range e.Props {
+					args = append(args, shellQuote(pr.String()))
+				}
+			}
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+func shellQuote(s string) string {
+	safe := s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./_-", r))
+	}) < 0
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Build creates every element with gst.NewElement and links each chain in
+// order. Elements with sometimes pads, like decodebin, are linked once the
+// pad appears.
+func (p *PipelineDesc) Build() (*gst.Pipeline, error) {
+	pipeline, err := gst.NewPipeline("")
+	if err != nil {
+		return nil, err
+	}
+	chains := p.chains()
+	elems := make([][]*gst.Element, len(chains))
+	named := map[string]*gst.Element{}
+	for i, c := range chains {
+		elems[i] = make([]*gst.Element, len(c.Elements))
+		for j, e := range c.Elements {
+			if e.Pad != "" {
+				continue
+			}
+			elem, err := e.newElement()
+			if err != nil {
+				return nil, err
+			}
+			if e.Setup != nil {
+				if err := e.Setup(elem); err != nil {
+					return nil, fmt.Errorf("%s: %w", e.Factory, err)
+				}
+			}
+			if err := pipeline.Add(elem); err != nil {
+				return nil, err
+			}
+			elems[i][j] = elem
+			named[elem.GetName()] = elem
+		}
+	}
+	// Resolve pad references now that every named element exists.
+	pads := make([][]string, len(chains))
+	for i, c := range chains {
+		pads[i] = make([]string, len(c.Elements))
+		for j, e := range c.Elements {
+			if e.Pad == "" {
+				continue
+			}
+			name, pad, _ := strings.Cut(e.Pad, ".")
+			elem, ok := named[name]
+			if !ok {
+				return nil, fmt.Errorf("%s: no element named %q", e.Pad, name)
+			}
+			elems[i][j], pads[i][j] = elem, pad
+		}
+	}
+	for i, c := range chains {
+		for j := 1; j < len(c.Elements); j++ {
+			if err := linkPads(elems[i][j-1], pads[i][j-1], elems[i][j], pads[i][j]); err != nil {
+				return nil, fmt.Errorf("linking %s: %w", c, err)
+			}
+		}
+	}
+	return pipeline, nil
+}
+
+// BuildBin builds a single chain into a bin named name, with the first
+// element's sink pad and the last element's src pad ghosted as sinkName and
+// srcName. It is for elements rtpbin asks for through signals.
+func (p *PipelineDesc) BuildBin(name, sinkName, srcName string) (*gst.Element, error) {
+	bin := gst.NewBin(name)
+	elems := make([]*gst.Element, len(p.Elements))
+	for i, e := range p.Elements {
+		elem, err := e.newElement()
+		if err != nil {
+			return nil, err
+		}
+		if e.Setup != nil {
+			if err := e.Setup(elem); err != nil {
+				return nil, fmt.Errorf("%s: %w", e.Factory, err)
+			}
+		}
+		if err := bin.Add(elem); err != nil {
+			return nil, err
+		}
+		elems[i] = elem
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("%s: no elements", name)
+	}
+	for i := 1; i < len(elems); i++ {
+		if err := linkElements(elems[i-1], elems[i]); err != nil {
+			return nil, fmt.Errorf("linking %s: %w", p, err)
+		}
+	}
+	bin.AddPad(gst.NewGhostPad(sinkName, elems[0].GetStaticPad("sink")).Pad)
+	bin.AddPad(gst.NewGhostPad(srcName, elems[len(elems)-1].GetStaticPad("src")).Pad)
+	return bin.Element, nil
+}
+
+// linkElements links src to sink, deferring the link to pad-added if src
+// creates its source pads at runtime. Pads whose caps do not fit sink, such
+// as the audio pad of a demuxed video file, are left unlinked.
+func linkElements(src, sink *gst.Element) error {
+	if !hasSometimesSrcPad(src) {
+		return src.Link(sink)
+	}
+	sinkPad := sink.GetStaticPad("sink")
+	if sinkPad == nil {
+		return fmt.Errorf("%s has no sink pad", sink.GetName())
+	}
+	return linkPadAdded(src, "", sinkPad)
+}
+
+// linkPads is linkElements between named pads. An empty name picks the
+// element's own pad as linkElements does; other names are looked up as
+// static or request pads, and src pads that do not exist yet are linked once
+// they are added.
+func linkPads(src *gst.Element, srcName string, sink *gst.Element, sinkName string) error {
+	if srcName == "" && sinkName == "" {
+		return linkElements(src, sink)
+	}
+	sinkPad := sink.GetStaticPad("sink")
+	if sinkName != "" {
+		sinkPad = namedPad(sink, sinkName, gst.PadDirectionSink)
+	}
+	if sinkPad == nil {
+		return fmt.Errorf("%s has no pad %q", sink.GetName(), sinkName)
+	}
+	var srcPad *gst.Pad
+	switch {
+	case srcName != "":
+		if srcPad = namedPad(src, srcName, gst.PadDirectionSource); srcPad == nil {
+			return linkPadAdded(src, srcName, sinkPad)
+		}
+	case hasSometimesSrcPad(src):
+		return linkPadAdded(src, "", sinkPad)
+	default:
+		srcPad = src.GetStaticPad("src")
+	}
+	if srcPad == nil {
+		return fmt.Errorf("%s has no src pad", src.GetName())
+	}
+	if ret := srcPad.Link(sinkPad); ret != gst.PadLinkOK {
+		return fmt.Errorf("%s:%s to %s:%s: %s", src.GetName(), srcPad.GetName(), sink.GetName(), sinkPad.GetName(), ret)
+	}
+	return nil
+}
+
+// linkPadAdded links the first source pad src adds whose caps fit sinkPad,
+// or only the one called name if it is set. Checking the caps first keeps a
+// demuxer's audio pad off the video branch however the pads are ordered.
+func linkPadAdded(src *gst.Element, name string, sinkPad *gst.Pad) error {
+	_, err := src.Connect("pad-added", func(_ *gst.Element, pad *gst.Pad) {
+		if pad.GetDirection() != gst.PadDirectionSource || sinkPad.IsLinked() || (name != "" && pad.GetName() != name) {
+			return
+		}
+		if !capsFit(pad, sinkPad) {
+			return
+		}
+		pad.Link(sinkPad)
+	})
+	return err
+}
+
+// capsFit reports whether the caps of src, fixed if it has negotiated them,
+// intersect those sink accepts.
+func capsFit(src, sink *gst.Pad) bool {
+	caps := src.GetCurrentCaps()
+	if caps == nil {
+		caps = src.QueryCaps(nil)
+	}
+	accepted := sink.QueryCaps(nil)
+	if caps == nil || accepted == nil {
+		return false
+	}
+	return caps.CanIntersect(accepted)
+}
+
+// namedPad returns the existing pad called name, or requests it if elem has
+// a request template for it.
+func namedPad(elem *gst.Element, name string, dir gst.PadDirection) *gst.Pad {
+	if pad := elem.GetStaticPad(name); pad != nil {
+		return pad
+	}
+	for _, tmpl := range elem.GetPadTemplates() {
+		prefix, _, _ := strings.Cut(tmpl.Name(), "%")
+		if tmpl.Direction() == dir && tmpl.Presence() == gst.PadPresenceRequest && strings.HasPrefix(name, prefix) {
+			return elem.GetRequestPad(name)
+		}
+	}
+	return nil
+}
+
+func hasSometimesSrcPad(elem *gst.Element) bool {
+	for _, tmpl := range elem.GetPadTemplates() {
+		if tmpl.Direction() == gst.PadDirectionSource && tmpl.Presence() == gst.PadPresenceSometimes {
+			return true
+		}
+	}
+	return false
+}
+
+func (e ElementDesc) newElement() (*gst.Element, error) {
+	if e.Factory == "" {
+		caps := gst.NewCapsFromString(e.Caps)
+		if caps == nil {
+			return nil, fmt.Errorf("invalid caps %q", e.Caps)
+		}
+		elem, err := gst.NewElement("capsfilter")
+		if err != nil {
+			return nil, err
+		}
+		if err := elem.SetProperty("caps", caps); err != nil {
+			return nil, err
+		}
+		return elem, nil
+	}
+	elem, err := gst.NewElement(e.Factory)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.Factory, err)
+	}
+	for _, pr := range e.Props {
+		// SetArg ignores unknown properties, so check for them first.
+		if _, err := elem.GetPropertyType(pr.Name); err != nil {
+			return nil, fmt.Errorf("%s has no property %q", e.Factory, pr.Name)
+		}
+		elem.SetArg(pr.Name, pr.Value)
+	}
+	return elem, nil
+}
+
+// findElementBySuffix returns the first element whose factory name ends in
+// suffix, such as the payloader of a sender.
+func findElementBySuffix(pipeline *gst.Pipeline, suffix string) (*gst.Element, error) {
+	elems, err := findElementsBySuffix(pipeline, suffix)
+	if err != nil {
+		return nil, err
+	}
+	return elems[0], nil
+}
+
+// findElementsBySuffix returns every element whose factory name ends in
+// suffix, failing if there are none.
+func findElementsBySuffix(pipeline *gst.Pipeline, suffix string) ([]*gst.Element, error) {
+	elems, err := pipeline.GetElements()
+	if err != nil {
+		return nil, err
+	}
+	var found []*gst.Element
+	for _, elem := range elems {
+		if factory := elem.GetFactory(); factory != nil && strings.HasSuffix(factory.GetName(), suffix) {
+			found = append(found, elem)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no *%s element in pipeline", suffix)
+	}
+	return found, nil
+}
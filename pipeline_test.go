@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenCase is one sender configuration whose video and audio pipelines
+// are compared against testdata/golden/<name>.golden.
+type goldenCase struct {
+	name          string
+	platform      string
+	variant       LinuxVariant
+	codec         Codec
+	linuxH264Mode LinuxH264Mode
+	audioCodec    AudioCodec
+}
+
+var goldenMode = Mode{Format: "NV12", Width: 1280, Height: 720, Framerate: "30/1"}
+
+// goldenCases returns every platform, variant, codec, H264 mode and audio
+// codec combination the sender can build. The H264 mode only varies where
+// the builders read it, codecs a board has no encoder for are left out as
+// the tool rejects them, and AAC is only paired with H264 as RTMP carries
+// nothing else.
+func goldenCases() []goldenCase {
+	type target struct {
+		platform string
+		variant  LinuxVariant
+	}
+	targets := []target{
+		{"darwin", LinuxGeneric},
+		{"linux", LinuxGeneric},
+		{"linux", LinuxRaspi},
+		{"linux", LinuxJetson},
+		{"linux", LinuxRock5},
+	}
+	var cases []goldenCase
+	for _, t := range targets {
+		for _, codec := range videoCodecs {
+			if len(encoderChain(t.platform, t.variant, codec, LinuxH264VAAPI, LinuxH265VAAPI)) == 0 {
+				continue
+			}
+			modes := []LinuxH264Mode{LinuxH264VAAPI}
+			if t.platform == "linux" && codec == CodecH264 && (t.variant == LinuxGeneric || t.variant == LinuxRaspi) {
+				modes = linuxH264Modes
+			}
+			for _, mode := range modes {
+				for _, audioCodec := range []AudioCodec{AudioOpus, AudioPCMU, AudioAAC} {
+					if audioCodec == AudioAAC && codec != CodecH264 {
+						continue
+					}
+					name := fmt.Sprintf("%s-%s-%s-%s-%s", t.platform, t.variant, codec, mode, audioCodec)
+					if t.platform != "linux" {
+						name = fmt.Sprintf("%s-%s-%s", t.platform, codec, audioCodec)
+					} else if len(modes) == 1 {
+						name = fmt.Sprintf("%s-%s-%s-%s", t.platform, t.variant, codec, audioCodec)
+					}
+					cases = append(cases, goldenCase{
+						name:          name,
+						platform:      t.platform,
+						variant:       t.variant,
+						codec:         codec,
+						linuxH264Mode: mode,
+						audioCodec:    audioCodec,
+					})
+				}
+			}
+		}
+	}
+	return cases
+}
+
+// senders builds the pipelines the tool runs for c, from capture devices
+// and with the first encoder of each chain: the video and audio rtpbin
+// senders with the default ports, or the RTMP publisher for AAC.
+func (c goldenCase) senders() []*PipelineDesc {
+	videoSrc := Source{Kind: SourceDevice, Factory: "avfvideosrc", Device: prop("device-index", 0)}
+	audioSrc := Source{Kind: SourceDevice, Factory: "osxaudiosrc", Device: prop("device", 73)}
+	if c.platform == "linux" {
+		videoSrc = Source{Kind: SourceDevice, Factory: "v4l2src", Device: prop("device", "/dev/video0")}
+		audioSrc = Source{Kind: SourceDevice, Factory: "pipewiresrc", Device: prop("target-object", "42")}
+	}
+	var encoder string
+	if chain := encoderChain(c.platform, c.variant, c.codec, c.linuxH264Mode, LinuxH265VAAPI); len(chain) > 0 {
+		encoder = chain[0]
+	}
+	video := buildVideoPipeline(c.platform, c.variant, videoSrc, goldenMode, c.codec, c.linuxH264Mode, encoder)
+	audio := buildAudioPipeline(c.platform, audioSrc, c.audioCodec)
+	if c.audioCodec == AudioAAC {
+		return []*PipelineDesc{buildRTMPPipeline(video, audio, aacEncoderChain(c.platform)[0], "rtmp://127.0.0.1/live", "key")}
+	}
+	const host, videoPort, audioPort = "127.0.0.1", 5000, 5001
+	videoRTCPPort, audioRTCPPort := defaultRTCPPorts(videoPort, audioPort)
+	videoStream := rtpStream{
+		Label:        "video",
+		Chain:        video,
+		Host:         host,
+		Port:         videoPort,
+		RTCPPort:     videoRTCPPort,
+		RTCPRecvPort: defaultRTCPRecvPort(host, videoRTCPPort),
+		ClockRate:    90000,
+		PT:           96,
+	}
+	audioStream := rtpStream{
+		Label:        "audio",
+		Chain:        audio,
+		Host:         host,
+		Port:         audioPort,
+		RTCPPort:     audioRTCPPort,
+		RTCPRecvPort: defaultRTCPRecvPort(host, audioRTCPPort),
+		ClockRate:    audioClockRate(c.audioCodec),
+		PT:           audioPayloadType(c.audioCodec),
+	}
+	return []*PipelineDesc{buildRTPBinPipeline(videoStream), buildRTPBinPipeline(audioStream)}
+}
+
+// golden is the content of c's golden file, one sender per line.
+func (c goldenCase) golden() string {
+	var b strings.Builder
+	for _, p := range c.senders() {
+		b.WriteString(p.String() + "\n")
+	}
+	return b.String()
+}
+
+func TestPipelineGolden(t *testing.T) {
+	for _, c := range goldenCases() {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.golden()
+			path := filepath.Join("testdata", "golden", c.name+".golden")
+			if *update {
+				if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if got != string(want) {
+				t.Errorf("pipelines differ from %s\ngot:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+// TestPipelineParse checks that gst_parse_launch accepts every generated
+// pipeline. Elements missing from this host's registry are replaced by
+// identity, so only the syntax, caps and linking of the rest are checked.
+func TestPipelineParse(t *testing.T) {
+	gst.Init(nil)
+	if gst.Find("identity") == nil {
+		t.Skip("GStreamer core elements are not installed")
+	}
+	for _, c := range goldenCases() {
+		t.Run(c.name, func(t *testing.T) {
+			for _, p := range c.senders() {
+				fake, missing := withFakeElements(p)
+				if missing != "" {
+					t.Skipf("%s is not installed and is linked by name", missing)
+				}
+				launch := fake.String()
+				if _, err := gst.NewPipelineFromString(launch); err != nil {
+					t.Fatalf("%s: %v", launch, err)
+				}
+			}
+		})
+	}
+}
+
+// withFakeElements returns a copy of p with every element this host does
+// not have replaced by a property-less identity. identity has none of the
+// request pads other chains link to by name, so a missing named element,
+// such as rtpbin, is returned instead.
+func withFakeElements(p *PipelineDesc) (*PipelineDesc, string) {
+	fake := &PipelineDesc{}
+	for _, e := range p.Elements {
+		if e.Factory != "" && gst.Find(e.Factory) == nil {
+			for _, pr := range e.Props {
+				if pr.Name == "name" {
+					return nil, e.Factory
+				}
+			}
+			e = ElementDesc{Factory: "identity"}
+		}
+		fake.Elements = append(fake.Elements, e)
+	}
+	for _, b := range p.Branches {
+		fb, missing := withFakeElements(b)
+		if missing != "" {
+			return nil, missing
+		}
+		fake.Branches = append(fake.Branches, fb)
+	}
+	return fake, ""
+}
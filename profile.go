@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// loadProfile reads a profile written by saveProfile.
+func loadProfile(path string) (*Options, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	opts := &Options{}
+	if err := json.Unmarshal(data, opts); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", path, err)
+	}
+	if err := opts.validate(); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", path, err)
+	}
+	return opts, nil
+}
+
+func saveProfile(path string, opts *Options) error {
+	data, err := json.MarshalIndent(opts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,751 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+type ReceiveSink string
+
+const (
+	SinkDisplay ReceiveSink = "display"
+	SinkCount   ReceiveSink = "count"
+)
+
+// ReceiveOptions holds the answers for the receive command. As with
+// Options, zero values are prompted for.
+type ReceiveOptions struct {
+	VideoPort  int
+	Codec      Codec
+	AudioPort  int
+	AudioCodec AudioCodec
+	Sink       ReceiveSink
+	Sync       bool
+	DryRun     bool
+
+	// RTCP ports, used with Sync. Receiver reports are only sent when
+	// SenderHost is set.
+	VideoRTCPPort     int
+	AudioRTCPPort     int
+	SenderHost        string
+	VideoRTCPSendPort int
+	AudioRTCPSendPort int
+
+	// RTX requests retransmissions on VideoRTXPT and AudioRTXPT, which
+	// needs Sync and SenderHost.
+	RTX        bool
+	VideoRTXPT int
+	AudioRTXPT int
+
+	// FEC recovers lost video packets from the sender's ULPFEC packets,
+	// which needs Sync.
+	FEC   bool
+	REDPT int
+	FECPT int
+
+	// SRTP decrypts the streams, and encrypts the receiver reports, with
+	// the sender's key.
+	SRTP        bool
+	SRTPKey     string
+	SRTPKeyFile string
+	SRTPCipher  SRTPCipher
+	SRTPAuth    SRTPAuth
+
+	// MulticastGroup, if set, is joined on MulticastIface to receive a
+	// sender's multicast streams. With Sync, receiver reports go to the
+	// group, with a TTL of MulticastTTL, unless SenderHost is set.
+	MulticastGroup string
+	MulticastIface string
+	MulticastTTL   int
+
+	// Transport srt receives the sender's MPEG-TS through srtsrc on
+	// VideoPort instead of RTP, calling SRTHost or listening on it.
+	// Transport whip answers a WHIP publisher over HTTP on VideoPort.
+	Transport     Transport
+	SRTHost       string
+	SRTMode       SRTMode
+	SRTLatency    int
+	SRTPassphrase string
+	SRTPBKeyLen   int
+	SRTStreamID   string
+}
+
+func parseReceiveOptions(args []string) (*ReceiveOptions, error) {
+	opts := &ReceiveOptions{}
+	var codec, audioCodec, sink, srtpCipher, srtpAuth, transport, srtMode string
+
+	fs := flag.NewFlagSet("cli receive", flag.ContinueOnError)
+	fs.IntVar(&opts.VideoPort, "video-port", 0, "video UDP port to listen on")
+	fs.StringVar(&codec, "codec", "", "video codec (H264, H265, VP8, VP9, AV1)")
+	fs.IntVar(&opts.AudioPort, "audio-port", 0, "audio UDP port to listen on")
+	fs.StringVar(&audioCodec, "audio-codec", "", "audio codec (OPUS, PCMU)")
+	fs.StringVar(&sink, "sink", "", "display the stream or count frames (display, count)")
+	fs.BoolVar(&opts.Sync, "sync", false, "receive both streams through one rtpbin and lip-sync them with the sender's RTCP (sender --sync)")
+	fs.IntVar(&opts.VideoRTCPPort, "video-rtcp-port", 0, "port for the video RTCP sender reports (default video-port+1, or the next free port)")
+	fs.IntVar(&opts.AudioRTCPPort, "audio-rtcp-port", 0, "port for the audio RTCP sender reports (default audio-port+1, or the next free port)")
+	fs.StringVar(&opts.SenderHost, "sender-host", "", "send RTCP receiver reports back to this host (needs --sync; default the multicast group)")
+	fs.IntVar(&opts.VideoRTCPSendPort, "video-rtcp-send-port", 0, "sender port for video receiver reports (default the sender's video-rtcp-recv-port)")
+	fs.IntVar(&opts.AudioRTCPSendPort, "audio-rtcp-send-port", 0, "sender port for audio receiver reports (default the sender's audio-rtcp-recv-port)")
+	fs.BoolVar(&opts.RTX, "rtx", false, "request retransmission of lost packets (needs --sync and --sender-host or --multicast-group)")
+	fs.IntVar(&opts.VideoRTXPT, "video-rtx-pt", 0, "payload type of video retransmissions (default 97)")
+	fs.IntVar(&opts.AudioRTXPT, "audio-rtx-pt", 0, "payload type of audio retransmissions (default 97)")
+	fs.BoolVar(&opts.FEC, "fec", false, "recover lost video packets from the sender's ULPFEC packets (needs --sync)")
+	fs.IntVar(&opts.REDPT, "red-pt", 0, "payload type of the RED packets with --fec (default 98)")
+	fs.IntVar(&opts.FECPT, "fec-pt", 0, "payload type of the ULPFEC packets with --fec (default 99)")
+	fs.BoolVar(&opts.SRTP, "srtp", false, "decrypt SRTP from a sender started with --srtp")
+	fs.StringVar(&opts.SRTPKey, "srtp-key", "", "the sender's SRTP key in hex (default $"+srtpKeyEnv+", then --srtp-key-file)")
+	fs.StringVar(&opts.SRTPKeyFile, "srtp-key-file", "", "file holding the sender's SRTP key in hex")
+	fs.StringVar(&srtpCipher, "srtp-cipher", "", "the sender's SRTP cipher (default aes-128-icm)")
+	fs.StringVar(&srtpAuth, "srtp-auth", "", "the sender's SRTP authentication (default hmac-sha1-80)")
+	fs.StringVar(&opts.MulticastGroup, "multicast-group", "", "join this multicast group, the sender's video and audio host")
+	fs.StringVar(&opts.MulticastIface, "multicast-iface", "", "interface to join the multicast group on")
+	fs.IntVar(&opts.MulticastTTL, "ttl-mc", 0, "TTL of receiver reports sent to the multicast group (default 1)")
+	fs.StringVar(&transport, "transport", "", "how the sender sends the streams (rtp, srt, whip; default rtp)")
+	fs.StringVar(&srtMode, "srt-mode", "", "SRT mode (caller, listener; default listener)")
+	fs.StringVar(&opts.SRTHost, "srt-host", "", "SRT listener to call, or address to listen on (default 127.0.0.1 for a caller, all addresses for a listener)")
+	fs.IntVar(&opts.SRTLatency, "srt-latency", 0, "SRT latency in milliseconds (default 120)")
+	fs.StringVar(&opts.SRTPassphrase, "srt-passphrase", "", "the sender's SRT passphrase (default $"+srtPassphraseEnv+")")
+	fs.IntVar(&opts.SRTPBKeyLen, "srt-pbkeylen", 0, "SRT AES key length in bytes (16, 24, 32; default 16)")
+	fs.StringVar(&opts.SRTStreamID, "srt-streamid", "", "SRT stream ID to send to the listener")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "print the gst-launch-1.0 commands instead of running them")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if err := rejectZeroFlags(fs); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if opts.VideoPort != 0 {
+		if err := validatePort(opts.VideoPort); err != nil {
+			return nil, fmt.Errorf("video-port: %w", err)
+		}
+	}
+	if opts.AudioPort != 0 {
+		if err := validatePort(opts.AudioPort); err != nil {
+			return nil, fmt.Errorf("audio-port: %w", err)
+		}
+	}
+	for _, p := range []struct {
+		name string
+		port int
+	}{
+		{"video-rtcp-port", opts.VideoRTCPPort},
+		{"audio-rtcp-port", opts.AudioRTCPPort},
+		{"video-rtcp-send-port", opts.VideoRTCPSendPort},
+		{"audio-rtcp-send-port", opts.AudioRTCPSendPort},
+	} {
+		if p.port != 0 {
+			if err := validatePort(p.port); err != nil {
+				return nil, fmt.Errorf("%s: %w", p.name, err)
+			}
+		}
+	}
+	if opts.MulticastGroup != "" {
+		if !isMulticast(opts.MulticastGroup) {
+			return nil, fmt.Errorf("multicast-group: %s is not a multicast address", opts.MulticastGroup)
+		}
+		if err := validateMulticastGroup(opts.MulticastGroup, opts.MulticastIface); err != nil {
+			return nil, fmt.Errorf("multicast-group: %w", err)
+		}
+	} else if opts.MulticastIface != "" || opts.MulticastTTL != 0 {
+		return nil, errors.New("multicast-iface and ttl-mc need --multicast-group")
+	}
+	if opts.MulticastIface != "" {
+		if _, err := net.InterfaceByName(opts.MulticastIface); err != nil {
+			return nil, fmt.Errorf("multicast-iface: %w", err)
+		}
+	}
+	if opts.MulticastTTL != 0 {
+		if err := validateTTL(opts.MulticastTTL); err != nil {
+			return nil, fmt.Errorf("ttl-mc: %w", err)
+		}
+	}
+	if opts.SenderHost != "" && !opts.Sync {
+		return nil, errors.New("sender-host needs --sync")
+	}
+	if opts.RTX && (!opts.Sync || opts.SenderHost == "" && opts.MulticastGroup == "") {
+		return nil, errors.New("rtx needs --sync and --sender-host or --multicast-group to send NACKs to")
+	}
+	if opts.FEC && !opts.Sync {
+		return nil, errors.New("fec needs --sync")
+	}
+	for _, pt := range []struct {
+		name string
+		pt   int
+	}{
+		{"video-rtx-pt", opts.VideoRTXPT},
+		{"audio-rtx-pt", opts.AudioRTXPT},
+		{"red-pt", opts.REDPT},
+		{"fec-pt", opts.FECPT},
+	} {
+		if pt.pt != 0 {
+			if err := validateDynamicPT(pt.pt); err != nil {
+				return nil, fmt.Errorf("%s: %w", pt.name, err)
+			}
+		}
+	}
+	if codec != "" {
+		if opts.Codec, err = parseCodec(codec); err != nil {
+			return nil, fmt.Errorf("codec: %w", err)
+		}
+	}
+	if audioCodec != "" {
+		if opts.AudioCodec, err = parseAudioCodec(audioCodec); err != nil {
+			return nil, fmt.Errorf("audio-codec: %w", err)
+		}
+		if opts.AudioCodec == AudioAAC {
+			return nil, errors.New("audio-codec: AAC is only sent over rtmp, which receive does not play")
+		}
+	}
+	if srtpCipher != "" {
+		if opts.SRTPCipher, err = parseSRTPCipher(srtpCipher); err != nil {
+			return nil, fmt.Errorf("srtp-cipher: %w", err)
+		}
+	}
+	if srtpAuth != "" {
+		if opts.SRTPAuth, err = parseSRTPAuth(srtpAuth); err != nil {
+			return nil, fmt.Errorf("srtp-auth: %w", err)
+		}
+	}
+	opts.Transport = TransportRTP
+	if transport != "" {
+		if opts.Transport, err = parseTransport(transport); err != nil {
+			return nil, fmt.Errorf("transport: %w", err)
+		}
+	}
+	if err := checkReceiveTransport(opts); err != nil {
+		return nil, err
+	}
+	if opts.Transport == TransportSRT {
+		opts.SRTMode = SRTListener
+		if srtMode != "" {
+			if opts.SRTMode, err = parseSRTMode(srtMode); err != nil {
+				return nil, fmt.Errorf("srt-mode: %w", err)
+			}
+		}
+		if opts.SRTHost == "" && opts.SRTMode == SRTCaller {
+			opts.SRTHost = "127.0.0.1"
+		}
+		opts.SRTPassphrase = flagOrEnv(opts.SRTPassphrase, srtPassphraseEnv)
+		if err := validateSRT(opts.SRTLatency, opts.SRTPassphrase, opts.SRTPBKeyLen); err != nil {
+			return nil, err
+		}
+	}
+	switch ReceiveSink(strings.ToLower(sink)) {
+	case "":
+	case SinkDisplay, SinkCount:
+		opts.Sink = ReceiveSink(strings.ToLower(sink))
+	default:
+		return nil, fmt.Errorf("sink: unknown sink %q", sink)
+	}
+	return opts, nil
+}
+
+// runReceive plays or counts the streams a sender started with the same
+// ports and codecs produces.
+func runReceive(mainLoop *glib.MainLoop, args []string) error {
+	opts, err := parseReceiveOptions(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	gst.Init(nil)
+
+	reader := bufio.NewReader(os.Stdin)
+
+	srt := opts.Transport == TransportSRT
+	whip := opts.Transport == TransportWHIP
+	if opts.VideoPort == 0 {
+		label, def := "Video UDP port", 5000
+		switch {
+		case srt:
+			label = "SRT port"
+		case whip:
+			label, def = "WHIP HTTP port", 8080
+		}
+		opts.VideoPort, err = promptPort(reader, label, def)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Codec == "" {
+		opts.Codec, err = promptDecoderCodec(reader)
+		if err != nil {
+			return err
+		}
+	}
+	if srt && opts.Codec != CodecH264 && opts.Codec != CodecH265 {
+		return fmt.Errorf("%s cannot be muxed into MPEG-TS; use H264 or H265", opts.Codec)
+	}
+	if whip && opts.Codec == CodecH265 {
+		return errors.New("H265 is not widely supported over WebRTC; use H264, VP8, VP9 or AV1")
+	}
+	decoder, err := selectDecoder(opts.Codec)
+	if err != nil {
+		return err
+	}
+	if srt {
+		// The audio arrives in the same MPEG-TS stream, always as Opus.
+		opts.AudioCodec = AudioOpus
+	}
+	if opts.AudioPort == 0 && !srt && !whip {
+		opts.AudioPort, err = promptPort(reader, "Audio UDP port", 5001)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.AudioCodec == "" {
+		opts.AudioCodec, err = promptAudioCodec(reader)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Sink == "" {
+		opts.Sink, err = promptReceiveSink(reader)
+		if err != nil {
+			return err
+		}
+	}
+
+	var srtp *srtpConfig
+	if opts.SRTP {
+		if srtp, err = loadSRTPConfig(opts.SRTPKey, opts.SRTPKeyFile, false, opts.SRTPCipher, opts.SRTPAuth); err != nil {
+			return err
+		}
+	}
+
+	srcProps := opts.multicast().srcProps(opts.MulticastGroup)
+	descs := []*PipelineDesc{
+		buildVideoReceiver(opts.VideoPort, opts.Codec, decoder, opts.Sink, srtp, srcProps),
+		buildAudioReceiver(opts.AudioPort, opts.AudioCodec, opts.Sink, srtp, srcProps),
+	}
+	labels := []string{"video", "audio"}
+	if srt {
+		descs = []*PipelineDesc{buildSRTReceiver(&srtConfig{
+			Mode:       opts.SRTMode,
+			Host:       opts.SRTHost,
+			Port:       opts.VideoPort,
+			Latency:    opts.SRTLatency,
+			Passphrase: opts.SRTPassphrase,
+			PBKeyLen:   opts.SRTPBKeyLen,
+			StreamID:   opts.SRTStreamID,
+		}, opts.Codec, decoder, opts.Sink)}
+		labels = []string{"srt"}
+	}
+	if whip {
+		descs = []*PipelineDesc{buildWHIPReceiver(opts.Codec, opts.AudioCodec, decoder, opts.Sink)}
+		labels = []string{"whip"}
+	}
+	if opts.Sync {
+		// Default to the ports a sender started with the same ports uses,
+		// which reads a multicast group's receiver reports from the group.
+		if opts.SenderHost == "" {
+			opts.SenderHost = opts.MulticastGroup
+		}
+		videoRTCPPort, audioRTCPPort := defaultRTCPPorts(opts.VideoPort, opts.AudioPort)
+		opts.VideoRTCPPort = orDefault(opts.VideoRTCPPort, videoRTCPPort)
+		opts.AudioRTCPPort = orDefault(opts.AudioRTCPPort, audioRTCPPort)
+		opts.VideoRTCPSendPort = orDefault(opts.VideoRTCPSendPort, defaultRTCPRecvPort(opts.SenderHost, opts.VideoRTCPPort))
+		opts.AudioRTCPSendPort = orDefault(opts.AudioRTCPSendPort, defaultRTCPRecvPort(opts.SenderHost, opts.AudioRTCPPort))
+		opts.VideoRTXPT = orDefault(opts.VideoRTXPT, 97)
+		opts.AudioRTXPT = orDefault(opts.AudioRTXPT, 97)
+		opts.REDPT = orDefault(opts.REDPT, 98)
+		opts.FECPT = orDefault(opts.FECPT, 99)
+		video := rtpStream{Label: "video", PT: 96}
+		if opts.RTX {
+			video.RTXPT = opts.VideoRTXPT
+		}
+		if opts.FEC {
+			video.REDPT, video.FECPT = opts.REDPT, opts.FECPT
+		}
+		if err := checkPayloadTypes(video); err != nil {
+			return err
+		}
+		if err := checkRTPPorts(
+			rtpStream{Label: "video", Port: opts.VideoPort, RTCPPort: opts.VideoRTCPPort},
+			rtpStream{Label: "audio", Port: opts.AudioPort, RTCPPort: opts.AudioRTCPPort},
+		); err != nil {
+			return err
+		}
+		descs = []*PipelineDesc{buildSyncReceiver(opts, decoder, srtp)}
+		labels = []string{"stream"}
+	}
+
+	if opts.DryRun {
+		for _, desc := range descs {
+			fmt.Println(desc.LaunchCommand())
+		}
+		if opts.RTX {
+			fmt.Println("# rtprtxreceive is added through rtpbin's request-aux-receiver signal, which gst-launch-1.0 cannot express")
+		}
+		if opts.SRTP {
+			fmt.Println("# srtpdec gets its key through its request-key signal, which gst-launch-1.0 cannot express")
+		}
+		if whip {
+			fmt.Println("# the WHIP offer and answer go through webrtcbin's signals, which gst-launch-1.0 cannot express")
+		}
+		if opts.FEC {
+			fmt.Println("# rtpreddec and rtpulpfecdec are added through rtpbin's request-aux-receiver and request-fec-decoder signals, which gst-launch-1.0 cannot express")
+		}
+		return nil
+	}
+
+	pipelines := make([]*gst.Pipeline, len(descs))
+	for i, desc := range descs {
+		if pipelines[i], err = desc.Build(); err != nil {
+			return err
+		}
+	}
+	for i, pipeline := range pipelines {
+		addPipelineWatch(pipeline, labels[i], mainLoop, pipelines, false)
+	}
+
+	if opts.Sink == SinkCount {
+		videoFrames, err := countBuffers(pipelines[0], "videosink")
+		if err != nil {
+			return err
+		}
+		audioPackets, err := countBuffers(pipelines[len(pipelines)-1], "audiosink")
+		if err != nil {
+			return err
+		}
+		var lastVideo, lastAudio uint64
+		if _, err := glib.TimeoutAdd(1000, func() bool {
+			v, a := videoFrames.Load(), audioPackets.Load()
+			fmt.Printf("video: %d frames (%d/s)  audio: %d buffers (%d/s)\n", v, v-lastVideo, a, a-lastAudio)
+			lastVideo, lastAudio = v, a
+			return true
+		}); err != nil {
+			return err
+		}
+	}
+
+	if whip {
+		webrtc, err := pipelines[0].GetElementByName("webrtc")
+		if err != nil {
+			return err
+		}
+		if err := serveWHIP(fmt.Sprintf(":%d", opts.VideoPort), webrtc, mainLoop.Quit); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case srt:
+		fmt.Printf("Receiving %s and %s over SRT as a %s on port %d\n", opts.Codec, opts.AudioCodec, opts.SRTMode, opts.VideoPort)
+	case whip:
+		fmt.Printf("Waiting for a WHIP publisher of %s and %s at http://127.0.0.1:%d/whip\n", opts.Codec, opts.AudioCodec, opts.VideoPort)
+	case opts.MulticastGroup != "":
+		fmt.Printf("Listening for %s on %s port %d and %s on port %d\n", opts.Codec, opts.MulticastGroup, opts.VideoPort, opts.AudioCodec, opts.AudioPort)
+	default:
+		fmt.Printf("Listening for %s on port %d and %s on port %d\n", opts.Codec, opts.VideoPort, opts.AudioCodec, opts.AudioPort)
+	}
+	for _, pipeline := range pipelines {
+		pipeline.SetState(gst.StatePlaying)
+	}
+
+	return mainLoop.RunError()
+}
+
+// countBuffers counts the buffers reaching the named fakesink.
+func countBuffers(pipeline *gst.Pipeline, name string) (*atomic.Uint64, error) {
+	sink, err := pipeline.GetElementByName(name)
+	if err != nil {
+		return nil, err
+	}
+	count := &atomic.Uint64{}
+	if _, err := sink.Connect("handoff", func() { count.Add(1) }); err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+// rtpVideoCaps returns the udpsrc caps for the payloader the senders use
+// for codec.
+func rtpVideoCaps(codec Codec) string {
+	return fmt.Sprintf("application/x-rtp,media=video,clock-rate=90000,encoding-name=%s,payload=96", codec)
+}
+
+func rtpAudioCaps(codec AudioCodec) string {
+	if codec == AudioPCMU {
+		return "application/x-rtp,media=audio,clock-rate=8000,encoding-name=PCMU,payload=0"
+	}
+	return "application/x-rtp,media=audio,clock-rate=48000,encoding-name=OPUS,payload=96"
+}
+
+// decoderChain returns the decoders for codec, hardware first. Elements
+// missing on this platform are skipped by selectDecoder.
+func decoderChain(codec Codec) []string {
+	switch codec {
+	case CodecH264:
+		return []string{"vtdec", "vah264dec", "avdec_h264", "openh264dec"}
+	case CodecH265:
+		return []string{"vtdec", "vah265dec", "avdec_h265", "libde265dec"}
+	case CodecVP8:
+		return []string{"vavp8dec", "vp8dec", "avdec_vp8"}
+	case CodecVP9:
+		return []string{"vavp9dec", "vp9dec", "avdec_vp9"}
+	default:
+		return []string{"vaav1dec", "dav1ddec", "av1dec", "avdec_av1"}
+	}
+}
+
+func probeDecoder(codec Codec) string {
+	for _, name := range decoderChain(codec) {
+		if gst.Find(name) != nil {
+			return name
+		}
+	}
+	return ""
+}
+
+func selectDecoder(codec Codec) (string, error) {
+	if decoder := probeDecoder(codec); decoder != "" {
+		return decoder, nil
+	}
+	return "", fmt.Errorf("no %s decoder available (tried %s)", codec, strings.Join(decoderChain(codec), ", "))
+}
+
+// multicast is the config for receiver reports sent to the multicast
+// group, or nil if there is none.
+func (o *ReceiveOptions) multicast() *multicastConfig {
+	if o.MulticastGroup == "" {
+		return nil
+	}
+	return &multicastConfig{TTL: orDefault(o.MulticastTTL, 1), Iface: o.MulticastIface, Loop: true}
+}
+
+// buildVideoReceiver receives codec on port, decrypting it first if srtp
+// is set. srcProps are extra udpsrc properties, such as the multicast ones.
+func buildVideoReceiver(port int, codec Codec, decoder string, sink ReceiveSink, srtp *srtpConfig, srcProps []Property) *PipelineDesc {
+	p := addRTPSource(&PipelineDesc{}, port, rtpVideoCaps(codec), srtp, srcProps).
+		Add("rtpjitterbuffer", prop("latency", 50))
+	return addVideoDecoder(p, codec, decoder, sink, false)
+}
+
+func buildAudioReceiver(port int, codec AudioCodec, sink ReceiveSink, srtp *srtpConfig, srcProps []Property) *PipelineDesc {
+	p := addRTPSource(&PipelineDesc{}, port, rtpAudioCaps(codec), srtp, srcProps).
+		Add("rtpjitterbuffer", prop("latency", 50))
+	return addAudioDecoder(p, codec, sink, false)
+}
+
+// addRTPSource appends the udpsrc for caps, and an srtpdec if srtp is set.
+func addRTPSource(p *PipelineDesc, port int, caps string, srtp *srtpConfig, srcProps []Property) *PipelineDesc {
+	if srtp == nil {
+		return p.UDPSrc(port, caps, srcProps...)
+	}
+	return p.UDPSrc(port, srtpCaps(caps), srcProps...).
+		Add("srtpdec").
+		Setup(srtp.connectRequestKey)
+}
+
+// buildSyncReceiver receives both streams through one rtpbin, which uses
+// the RTCP sender reports to play them in sync and, with a sender host,
+// returns receiver reports.
+func buildSyncReceiver(opts *ReceiveOptions, decoder string, srtp *srtpConfig) *PipelineDesc {
+	p := &PipelineDesc{}
+	var retransmit Property
+	if opts.RTX {
+		retransmit = prop("do-retransmission", true)
+	}
+	p.Add("rtpbin", prop("name", "rtpbin"), prop("latency", 50), rtpProfile(opts.RTX, srtp != nil), retransmit)
+	if opts.RTX || opts.FEC {
+		video := rtpRecvSession{Media: "video", ClockRate: 90000, Caps: rtpVideoCaps(opts.Codec), PT: 96}
+		audio := rtpRecvSession{Media: "audio", ClockRate: audioClockRate(opts.AudioCodec), Caps: rtpAudioCaps(opts.AudioCodec), PT: audioPayloadType(opts.AudioCodec)}
+		if opts.RTX {
+			video.RTXPT, audio.RTXPT = opts.VideoRTXPT, opts.AudioRTXPT
+		}
+		if opts.FEC {
+			video.REDPT, video.FECPT = opts.REDPT, opts.FECPT
+		}
+		sessions := []rtpRecvSession{video, audio}
+		p.Setup(func(rtpbin *gst.Element) error { return setupRTPBinReceiver(rtpbin, sessions) })
+	}
+	mc := opts.multicast()
+	srcProps := mc.srcProps(opts.MulticastGroup)
+	var sinkProps []Property
+	if opts.SenderHost == opts.MulticastGroup {
+		sinkProps = mc.sinkProps()
+	}
+	for i, s := range []struct {
+		port, rtcpPort, sendPort int
+		caps                     string
+	}{
+		{opts.VideoPort, opts.VideoRTCPPort, opts.VideoRTCPSendPort, rtpVideoCaps(opts.Codec)},
+		{opts.AudioPort, opts.AudioRTCPPort, opts.AudioRTCPSendPort, rtpAudioCaps(opts.AudioCodec)},
+	} {
+		var enc, dec string
+		rtpSrc := (&PipelineDesc{}).UDPSrc(s.port, s.caps, srcProps...)
+		rtcpSrc := (&PipelineDesc{}).Add("udpsrc", append([]Property{prop("port", s.rtcpPort)}, srcProps...)...)
+		if srtp != nil {
+			enc, dec = fmt.Sprintf("srtpenc%d", i), fmt.Sprintf("srtpdec%d", i)
+			addSRTPDecoder(p, dec, srtp)
+			rtpSrc = (&PipelineDesc{}).UDPSrc(s.port, srtpCaps(s.caps), srcProps...)
+			rtcpSrc = (&PipelineDesc{}).UDPSrc(s.rtcpPort, "application/x-srtcp", srcProps...)
+		}
+		srtpReceive(p, rtpSrc, dec, "rtp", fmt.Sprintf("rtpbin.recv_rtp_sink_%d", i))
+		srtpReceive(p, rtcpSrc, dec, "rtcp", fmt.Sprintf("rtpbin.recv_rtcp_sink_%d", i))
+		if opts.SenderHost != "" {
+			if srtp != nil {
+				addSRTPEncoder(p, enc, srtp)
+			}
+			srtpSend(p, fmt.Sprintf("rtpbin.send_rtcp_src_%d", i), enc, "rtcp").
+				UDPSink(opts.SenderHost, s.sendPort, sinkProps...)
+		}
+	}
+	addVideoDecoder(p.Branch().Pad("rtpbin."), opts.Codec, decoder, opts.Sink, true)
+	addAudioDecoder(p.Branch().Pad("rtpbin."), opts.AudioCodec, opts.Sink, true)
+	return p
+}
+
+// buildSRTReceiver plays the MPEG-TS a sender started with --transport srt
+// sends. Each branch starts with a parser that only accepts its stream's
+// caps, so tsdemux links every pad it adds to the right branch.
+func buildSRTReceiver(srt *srtConfig, codec Codec, decoder string, sink ReceiveSink) *PipelineDesc {
+	p := &PipelineDesc{}
+	p.Add("srtsrc", srt.props()...).
+		Add("tsdemux", prop("name", "demux"))
+	video := p.Branch().Pad("demux.")
+	if codec == CodecH265 {
+		video.Add("h265parse")
+	} else {
+		video.Add("h264parse")
+	}
+	addVideoOutput(video.Add("queue"), decoder, sink, true)
+	addAudioOutput(p.Branch().Pad("demux.").Add("opusparse").Add("queue").Add("opusdec"), sink, true)
+	return p
+}
+
+// checkReceiveTransport fails if opts asks for something the transport
+// the streams arrive on cannot carry.
+func checkReceiveTransport(opts *ReceiveOptions) error {
+	switch opts.Transport {
+	case TransportRTP:
+		return nil
+	case TransportSRT, TransportWHIP:
+	default:
+		return fmt.Errorf("transport: receive supports rtp, srt and whip, not %s", opts.Transport)
+	}
+	for _, o := range []struct {
+		name string
+		set  bool
+	}{
+		{"audio-port", opts.AudioPort != 0},
+		{"sync", opts.Sync},
+		{"rtx", opts.RTX},
+		{"fec", opts.FEC},
+		{"srtp", opts.SRTP},
+		{"multicast-group", opts.MulticastGroup != ""},
+	} {
+		if o.set {
+			return fmt.Errorf("--%s needs the rtp transport", o.name)
+		}
+	}
+	if opts.Transport == TransportSRT && opts.AudioCodec == AudioPCMU {
+		return errors.New("PCMU cannot be muxed into MPEG-TS; use OPUS")
+	}
+	return nil
+}
+
+// addVideoDecoder appends the depayloader, decoder and sink for codec. With
+// sync the display sink renders on the clock, which lip sync needs.
+func addVideoDecoder(p *PipelineDesc, codec Codec, decoder string, sink ReceiveSink, sync bool) *PipelineDesc {
+	switch codec {
+	case CodecH265:
+		p.Add("rtph265depay").Add("h265parse")
+	case CodecVP8:
+		p.Add("rtpvp8depay")
+	case CodecVP9:
+		p.Add("rtpvp9depay").Add("vp9parse")
+	case CodecAV1:
+		p.Add("rtpav1depay").Add("av1parse")
+	default:
+		p.Add("rtph264depay").Add("h264parse")
+	}
+	return addVideoOutput(p, decoder, sink, sync)
+}
+
+// addVideoOutput appends decoder and the sink for parsed video.
+func addVideoOutput(p *PipelineDesc, decoder string, sink ReceiveSink, sync bool) *PipelineDesc {
+	p.Add(decoder).
+		Add("videoconvert")
+	if sink == SinkCount {
+		return p.Add("fakesink", prop("name", "videosink"), prop("signal-handoffs", true), prop("sync", false))
+	}
+	return p.Add("autovideosink", prop("sync", sync))
+}
+
+// addAudioDecoder is addVideoDecoder for audio.
+func addAudioDecoder(p *PipelineDesc, codec AudioCodec, sink ReceiveSink, sync bool) *PipelineDesc {
+	switch codec {
+	case AudioPCMU:
+		p.Add("rtppcmudepay").Add("mulawdec")
+	default:
+		p.Add("rtpopusdepay").Add("opusdec")
+	}
+	return addAudioOutput(p, sink, sync)
+}
+
+// addAudioOutput appends the sink for decoded audio.
+func addAudioOutput(p *PipelineDesc, sink ReceiveSink, sync bool) *PipelineDesc {
+	p.Add("audioconvert").
+		Add("audioresample")
+	if sink == SinkCount {
+		return p.Add("fakesink", prop("name", "audiosink"), prop("signal-handoffs", true), prop("sync", false))
+	}
+	return p.Add("autoaudiosink", prop("sync", sync))
+}
+
+// promptDecoderCodec offers the codecs that have a decoder in the registry.
+func promptDecoderCodec(reader *bufio.Reader) (Codec, error) {
+	var codecs []Codec
+	var options []string
+	for _, c := range videoCodecs {
+		decoder := probeDecoder(c)
+		if decoder == "" {
+			fmt.Printf("%s unavailable: no decoder found\n", c)
+			continue
+		}
+		codecs = append(codecs, c)
+		options = append(options, fmt.Sprintf("%s (%s)", c, decoder))
+	}
+	if len(codecs) == 0 {
+		return CodecH264, errors.New("no video decoders available")
+	}
+	idx, err := promptChoice(reader, "Select a codec", options)
+	if err != nil {
+		return CodecH264, err
+	}
+	return codecs[idx], nil
+}
+
+func promptReceiveSink(reader *bufio.Reader) (ReceiveSink, error) {
+	options := []string{
+		"Display (autovideosink, autoaudiosink)",
+		"Count frames (fakesink)",
+	}
+	idx, err := promptChoice(reader, "Select an output", options)
+	if err != nil {
+		return SinkDisplay, err
+	}
+	if idx == 1 {
+		return SinkCount, nil
+	}
+	return SinkDisplay, nil
+}
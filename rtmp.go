@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// rtmpKeyEnv is the environment variable the RTMP stream key is read from
+// when no --rtmp-key is given.
+const rtmpKeyEnv = "CLI_RTMP_KEY"
+
+func validateRTMPURL(val string) error {
+	u, err := url.Parse(val)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "rtmp" && u.Scheme != "rtmps") || u.Host == "" {
+		return fmt.Errorf("%q is not an rtmp or rtmps URL", val)
+	}
+	return nil
+}
+
+// rtmpLocation is the rtmp2sink location for the ingest URL and stream
+// key. Ingest servers take the key as the last path element.
+func rtmpLocation(ingest, key string) string {
+	if key == "" {
+		return ingest
+	}
+	return strings.TrimSuffix(ingest, "/") + "/" + key
+}
+
+// rtmpLocationProp is the rtmp2sink location, printed with the stream key
+// masked.
+func rtmpLocationProp(ingest, key string) Property {
+	if key == "" {
+		return prop("location", ingest)
+	}
+	return secretProp("location", rtmpLocation(ingest, key), rtmpLocation(ingest, "****"))
+}
+
+// aacEncoderChain returns the AAC encoders for platform, best first.
+func aacEncoderChain(platform string) []string {
+	chain := []string{"fdkaacenc", "avenc_aac", "voaacenc", "faac"}
+	if platform == "darwin" {
+		return append([]string{"atenc"}, chain...)
+	}
+	return chain
+}
+
+func selectAACEncoder(platform string) (string, error) {
+	for _, name := range aacEncoderChain(platform) {
+		if gst.Find(name) != nil {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no AAC encoder available (tried %s)", strings.Join(aacEncoderChain(platform), ", "))
+}
+
+// buildRTMPPipeline muxes the H264 video chain, without its payloader, and
+// the raw audio chain, encoded to AAC, into FLV for rtmp2sink to publish to
+// ingest with key.
+func buildRTMPPipeline(video, audio *PipelineDesc, aacEncoder, ingest, key string) *PipelineDesc {
+	p := &PipelineDesc{}
+	p.Add("flvmux", prop("name", "mux"), prop("streamable", true)).
+		Add("rtmp2sink", rtmpLocationProp(ingest, key), prop("sync", false))
+	p.Branches = append(p.Branches,
+		withParser(trimPayloader(video), "h264parse").Add("queue").Pad("mux.video"),
+		audio.Add(aacEncoder).Add("aacparse").Add("queue").Pad("mux.audio"))
+	return p
+}
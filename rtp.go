@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// rtpStream is one payloader chain sent through rtpbin. Its RTCP sender
+// reports go to RTCPPort on the same host, and receiver reports are read
+// from RTCPRecvPort on this machine.
+type rtpStream struct {
+	Label        string
+	Chain        *PipelineDesc
+	Host         string
+	Port         int
+	RTCPPort     int
+	RTCPRecvPort int
+	// ClockRate converts the reported jitter from RTP units.
+	ClockRate int
+	// PT is the payloader's payload type. A non-zero RTXPT retransmits
+	// packets NACKed by the receiver on that payload type, from a history
+	// of RTXHistory milliseconds.
+	PT         int
+	RTXPT      int
+	RTXHistory int
+	// A non-zero FECPT adds ULPFEC packets of that payload type worth
+	// FECPercent of the media packets, and wraps both in RED packets of
+	// REDPT.
+	REDPT      int
+	FECPT      int
+	FECPercent int
+	// Multicast is set when Host is a multicast group.
+	Multicast *multicastConfig
+	// SRTP, if set, encrypts the RTP and RTCP and decrypts the receiver
+	// reports.
+	SRTP *srtpConfig
+}
+
+// buildRTPBinPipeline sends every stream through one rtpbin. The streams
+// share the pipeline clock, and rtpbin's RTCP sender reports map each
+// stream's RTP timestamps to the same NTP time, which is what receivers
+// need to lip-sync them. Stream i is rtpbin session i.
+func buildRTPBinPipeline(streams ...rtpStream) *PipelineDesc {
+	p := &PipelineDesc{}
+	var feedback, secure bool
+	for _, s := range streams {
+		// NACKs need the AVPF feedback profile.
+		feedback = feedback || s.RTXPT != 0
+		secure = secure || s.SRTP != nil
+	}
+	p.Add("rtpbin", prop("name", "rtpbin"), rtpProfile(feedback, secure))
+	if feedback {
+		p.Setup(func(rtpbin *gst.Element) error { return setupRTPBinSender(rtpbin, streams) })
+	}
+	for i, s := range streams {
+		if s.FECPT != 0 {
+			s.Chain.Add("rtpulpfecenc", prop("pt", s.FECPT), prop("percentage", s.FECPercent)).
+				Add("rtpredenc", prop("pt", s.REDPT), prop("allow-no-red-blocks", true))
+		}
+		p.Branches = append(p.Branches, s.Chain.Pad(fmt.Sprintf("rtpbin.send_rtp_sink_%d", i)))
+		var enc, dec string
+		// Receivers of a multicast stream send their reports to the group.
+		rtcpProps := append([]Property{prop("port", s.RTCPRecvPort)}, s.Multicast.srcProps(s.Host)...)
+		if s.SRTP != nil {
+			enc, dec = fmt.Sprintf("srtpenc%d", i), fmt.Sprintf("srtpdec%d", i)
+			addSRTPEncoder(p, enc, s.SRTP)
+			addSRTPDecoder(p, dec, s.SRTP)
+			rtcpProps = append(rtcpProps, prop("caps", "application/x-srtcp"))
+		}
+		srtpSend(p, fmt.Sprintf("rtpbin.send_rtp_src_%d", i), enc, "rtp").
+			UDPSink(s.Host, s.Port, s.Multicast.sinkProps()...)
+		srtpSend(p, fmt.Sprintf("rtpbin.send_rtcp_src_%d", i), enc, "rtcp").
+			UDPSink(s.Host, s.RTCPPort, s.Multicast.sinkProps()...)
+		srtpReceive(p, (&PipelineDesc{}).Add("udpsrc", rtcpProps...), dec, "rtcp", fmt.Sprintf("rtpbin.recv_rtcp_sink_%d", i))
+	}
+	return p
+}
+
+// setupRTPBinSender connects the rtpbin signal, which gst-launch has no
+// syntax for, that adds rtprtxsend as aux sender to the sessions with RTX.
+// It must run before the sessions' pads are requested.
+//
+// Handlers cannot return NULL through go-glib, so sessions without RTX get
+// an identity instead.
+func setupRTPBinSender(rtpbin *gst.Element, streams []rtpStream) error {
+	_, err := rtpbin.Connect("request-aux-sender", func(_ *gst.Element, session uint) *gst.Element {
+		s := streams[session]
+		p := &PipelineDesc{}
+		if s.RTXPT != 0 {
+			p.Add("rtprtxsend",
+				prop("payload-type-map", rtxPTMap(s.outerPT(), s.RTXPT)),
+				prop("max-size-time", s.RTXHistory),
+				prop("max-size-packets", 0))
+		}
+		return requestedBin(p, fmt.Sprintf("aux%d", session), fmt.Sprintf("sink_%d", session), fmt.Sprintf("src_%d", session))
+	})
+	return err
+}
+
+// rtpProfile is rtpbin's rtp-profile property, left out for plain AVP.
+func rtpProfile(feedback, secure bool) Property {
+	switch {
+	case feedback && secure:
+		return prop("rtp-profile", "savpf")
+	case feedback:
+		return prop("rtp-profile", "avpf")
+	case secure:
+		return prop("rtp-profile", "savp")
+	}
+	return Property{}
+}
+
+// rtxPTMap maps payload type pt to its retransmission payload type.
+func rtxPTMap(pt, rtxPT int) string {
+	return fmt.Sprintf("application/x-rtp-pt-map,%d=(uint)%d", pt, rtxPT)
+}
+
+// outerPT is the payload type of the packets leaving the stream's chain,
+// which is RED's when FEC is on.
+func (s rtpStream) outerPT() int {
+	if s.REDPT != 0 {
+		return s.REDPT
+	}
+	return s.PT
+}
+
+// checkPayloadTypes fails if a stream uses a payload type for two things.
+func checkPayloadTypes(streams ...rtpStream) error {
+	for _, s := range streams {
+		used := map[int]bool{s.PT: true}
+		for _, pt := range []int{s.RTXPT, s.REDPT, s.FECPT} {
+			if pt == 0 {
+				continue
+			}
+			if used[pt] {
+				return fmt.Errorf("%s payload type %d is used twice", s.Label, pt)
+			}
+			used[pt] = true
+		}
+	}
+	return nil
+}
+
+// rtpRecvSession is what a receiving rtpbin session has to undo. Caps
+// describe the media on payload type PT; the other payload types are set
+// when the sender uses RTX or FEC.
+type rtpRecvSession struct {
+	Media     string
+	ClockRate int
+	Caps      string
+	PT        int
+	RTXPT     int
+	REDPT     int
+	FECPT     int
+}
+
+func (s rtpRecvSession) outerPT() int {
+	return rtpStream{PT: s.PT, REDPT: s.REDPT}.outerPT()
+}
+
+// setupRTPBinReceiver connects the rtpbin signals, which gst-launch has no
+// syntax for, that add rtprtxreceive and rtpreddec as aux receivers and
+// rtpulpfecdec as FEC decoder, and that give the jitterbuffers caps for
+// the extra payload types. It must run before the sessions' pads are
+// requested.
+//
+// Handlers cannot return NULL through go-glib, so sessions without RTX or
+// FEC get an identity instead.
+func setupRTPBinReceiver(rtpbin *gst.Element, sessions []rtpRecvSession) error {
+	if _, err := rtpbin.Connect("request-pt-map", func(_ *gst.Element, session, pt uint) *gst.Caps {
+		s := sessions[session]
+		name := "RTX"
+		switch {
+		case int(pt) == s.PT:
+			return gst.NewCapsFromString(s.Caps)
+		case int(pt) == s.REDPT:
+			name = "RED"
+		case int(pt) == s.FECPT:
+			name = "ULPFEC"
+		}
+		return gst.NewCapsFromString(fmt.Sprintf("application/x-rtp,media=%s,clock-rate=%d,encoding-name=%s,payload=%d",
+			s.Media, s.ClockRate, name, pt))
+	}); err != nil {
+		return err
+	}
+	if _, err := rtpbin.Connect("request-aux-receiver", func(_ *gst.Element, session uint) *gst.Element {
+		s := sessions[session]
+		p := &PipelineDesc{}
+		if s.RTXPT != 0 {
+			p.Add("rtprtxreceive", prop("payload-type-map", rtxPTMap(s.outerPT(), s.RTXPT)))
+		}
+		if s.REDPT != 0 {
+			p.Add("rtpreddec", prop("pt", s.REDPT))
+		}
+		return requestedBin(p, fmt.Sprintf("aux%d", session), fmt.Sprintf("sink_%d", session), fmt.Sprintf("src_%d", session))
+	}); err != nil {
+		return err
+	}
+	_, err := rtpbin.Connect("request-fec-decoder", func(_ *gst.Element, session uint) *gst.Element {
+		s := sessions[session]
+		p := &PipelineDesc{}
+		if s.FECPT != 0 {
+			p.Add("rtpulpfecdec", prop("pt", s.FECPT)).
+				Setup(func(dec *gst.Element) error { return setFECStorage(rtpbin, session, dec) })
+		}
+		return requestedBin(p, fmt.Sprintf("fecdec%d", session), "sink", "src")
+	})
+	return err
+}
+
+// requestedBin builds p for an rtpbin signal handler, falling back to an
+// identity if p is empty or fails to build.
+func requestedBin(p *PipelineDesc, name, sinkName, srcName string) *gst.Element {
+	if len(p.Elements) > 0 {
+		bin, err := p.BuildBin(name, sinkName, srcName)
+		if err == nil {
+			return bin
+		}
+		fmt.Println("ERROR (rtpbin):", err)
+	}
+	bin, _ := (&PipelineDesc{}).Add("identity").BuildBin(name, sinkName, srcName)
+	return bin
+}
+
+// setFECStorage points rtpulpfecdec at the packets rtpbin keeps for the
+// session, which it recovers lost packets from.
+func setFECStorage(rtpbin *gst.Element, session uint, dec *gst.Element) error {
+	v, err := rtpbin.Emit("get-storage", session)
+	if err != nil {
+		return err
+	}
+	storage, ok := v.(interface {
+		SetProperty(string, interface{}) error
+	})
+	if !ok {
+		return fmt.Errorf("rtpbin has no storage for session %d", session)
+	}
+	// Keep packets long enough for the FEC packets protecting them to arrive.
+	if err := storage.SetProperty("size-time", uint64(250*time.Millisecond)); err != nil {
+		return err
+	}
+	return dec.SetProperty("storage", v)
+}
+
+func audioPayloadType(codec AudioCodec) int {
+	if codec == AudioPCMU {
+		return 0
+	}
+	return 96
+}
+
+func audioClockRate(codec AudioCodec) int {
+	if codec == AudioPCMU {
+		return 8000
+	}
+	return 48000
+}
+
+// defaultRTCPRecvPort is the port a sender reads receiver reports on: its
+// RTCP port, or 4 above it when sending to this machine, where the
+// receiver already listens on the RTCP port, or to a multicast group, whose
+// RTCP port also carries the sender's own looped-back reports.
+func defaultRTCPRecvPort(host string, rtcpPort int) int {
+	if isLoopback(host) || isMulticast(host) {
+		return rtcpPort + 4
+	}
+	return rtcpPort
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// defaultRTCPPorts returns the RTCP ports for the video and audio RTP
+// ports: each port + 1, unless another stream already has it, as the audio
+// does with the default ports 5000 and 5001, in which case the next free
+// port above both. Senders and receivers given the same ports agree.
+func defaultRTCPPorts(videoPort, audioPort int) (video, audio int) {
+	used := map[int]bool{videoPort: true, audioPort: true}
+	next := max(videoPort, audioPort) + 1
+	pick := func(port int) int {
+		rtcp := port + 1
+		for used[rtcp] {
+			rtcp, next = next, next+1
+		}
+		used[rtcp] = true
+		return rtcp
+	}
+	video = pick(videoPort)
+	audio = pick(audioPort)
+	return video, audio
+}
+
+// checkRTPPorts fails if a port is invalid or two of the streams' ports
+// would collide. Ports on a loopback host are on this machine, like the
+// receive ports.
+func checkRTPPorts(streams ...rtpStream) error {
+	used := map[string]string{}
+	for _, s := range streams {
+		host := s.Host
+		if isLoopback(host) {
+			host = ""
+		}
+		for _, p := range []struct {
+			name string
+			host string
+			port int
+		}{
+			{s.Label + " port", host, s.Port},
+			{s.Label + " RTCP port", host, s.RTCPPort},
+			{s.Label + " RTCP receive port", "", s.RTCPRecvPort},
+		} {
+			if p.port == 0 {
+				continue
+			}
+			if err := validatePort(p.port); err != nil {
+				return fmt.Errorf("%s: %w", p.name, err)
+			}
+			key := net.JoinHostPort(p.host, fmt.Sprint(p.port))
+			if other, ok := used[key]; ok {
+				return fmt.Errorf("%s %d is also the %s", p.name, p.port, other)
+			}
+			used[key] = p.name
+		}
+	}
+	return nil
+}
+
+// watchReceiverReports prints the loss, jitter and round-trip time from
+// every RTCP receiver report the streams' rtpbin gets.
+func watchReceiverReports(pipeline *gst.Pipeline, streams []rtpStream) error {
+	rtpbin, err := pipeline.GetElementByName("rtpbin")
+	if err != nil {
+		return err
+	}
+	_, err = rtpbin.Connect("on-ssrc-active", func(_ *gst.Element, session, ssrc uint) {
+		if int(session) >= len(streams) {
+			return
+		}
+		stats := sourceStats(rtpbin, session, ssrc)
+		if stats == nil {
+			return
+		}
+		values := stats.Values()
+		if have, _ := values["have-rb"].(bool); !have {
+			return
+		}
+		s := streams[session]
+		lost := float64(intProp(values, "rb-fractionlost")) * 100 / 256
+		jitter := float64(intProp(values, "rb-jitter")) * 1000 / float64(s.ClockRate)
+		// The round trip is in 1/65536 s, and 0 until an SR has been answered.
+		rtt := float64(intProp(values, "rb-round-trip")) * 1000 / 65536
+		fmt.Printf("[%s] receiver %08x: %.1f%% lost (%d total), jitter %.1f ms, round trip %.1f ms\n",
+			s.Label, ssrc, lost, intProp(values, "rb-packetslost"), jitter, rtt)
+	})
+	return err
+}
+
+// sourceStats returns the stats of an RTP source in an rtpbin session, or
+// nil if it is gone.
+func sourceStats(rtpbin *gst.Element, session, ssrc uint) *gst.Structure {
+	sess, err := rtpbin.Emit("get-internal-session", session)
+	if err != nil {
+		return nil
+	}
+	sessObj, ok := sess.(*glib.Object)
+	if !ok || sessObj == nil {
+		return nil
+	}
+	src, err := sessObj.Emit("get-source-by-ssrc", ssrc)
+	if err != nil {
+		return nil
+	}
+	srcObj, ok := src.(*glib.Object)
+	if !ok || srcObj == nil {
+		return nil
+	}
+	stats, err := srcObj.GetProperty("stats")
+	if err != nil {
+		return nil
+	}
+	s, _ := stats.(*gst.Structure)
+	return s
+}
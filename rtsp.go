@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-gst/go-glib/glib"
+)
+
+// rtspPasswordEnv is the environment variable the RTSP password is read
+// from when no --rtsp-password is given.
+const rtspPasswordEnv = "CLI_RTSP_PASSWORD"
+
+// rtspServerConfig is where serve-rtsp listens and who may play the stream.
+type rtspServerConfig struct {
+	Address  string
+	Port     int
+	Mount    string
+	User     string
+	Password string
+	// TCPOnly makes clients interleave RTP in the RTSP connection, which
+	// gets through NATs and firewalls that drop the UDP streams.
+	TCPOnly bool
+}
+
+func (c *rtspServerConfig) url() string {
+	return "rtsp://" + net.JoinHostPort(c.Address, strconv.Itoa(c.Port)) + c.Mount
+}
+
+func validateRTSPMount(mount string) error {
+	if !strings.HasPrefix(mount, "/") {
+		return fmt.Errorf("%q does not start with /", mount)
+	}
+	return nil
+}
+
+// rtspLaunch is the media factory's launch line for the video and audio
+// chains. The factory serves each payloader named payN as a stream.
+func rtspLaunch(video, audio *PipelineDesc) string {
+	for i, p := range []*PipelineDesc{video, audio} {
+		last := &p.Elements[len(p.Elements)-1]
+		last.Props = append(last.Props, prop("name", fmt.Sprintf("pay%d", i)))
+	}
+	p := &PipelineDesc{Elements: video.Elements, Branches: []*PipelineDesc{audio}}
+	return "( " + p.String() + " )"
+}
+
+// runRTSPServer serves the video and audio chains, up to their payloaders,
+// until the main loop ends.
+func runRTSPServer(mainLoop *glib.MainLoop, opts *Options, video, audio *PipelineDesc) error {
+	c := &rtspServerConfig{
+		Address:  opts.VideoHost,
+		Port:     opts.VideoPort,
+		Mount:    opts.RTSPMount,
+		User:     opts.RTSPUser,
+		Password: flagOrEnv(opts.RTSPPassword, rtspPasswordEnv),
+		TCPOnly:  opts.RTSPTCP,
+	}
+	if c.Mount == "" {
+		c.Mount = "/stream"
+	}
+	if c.User != "" && c.Password == "" {
+		return fmt.Errorf("rtsp-user needs --rtsp-password or $%s", rtspPasswordEnv)
+	}
+	if c.User == "" && opts.RTSPPassword != "" {
+		return errors.New("rtsp-password needs --rtsp-user")
+	}
+	launch := rtspLaunch(video, audio)
+	if opts.DryRun {
+		fmt.Println(launch)
+		return nil
+	}
+	if err := serveRTSP(c, launch); err != nil {
+		return err
+	}
+	fmt.Printf("Serving %s and %s at %s\n", opts.Codec, opts.AudioCodec, c.url())
+	return mainLoop.RunError()
+}
@@ -0,0 +1,75 @@
+//go:build rtsp
+
+package main
+
+// go-gst has no bindings for gst-rtsp-server, so the few calls serve-rtsp
+// needs are made through cgo.
+
+// #cgo pkg-config: gstreamer-rtsp-server-1.0
+// #include <stdlib.h>
+// #include <gst/rtsp-server/rtsp-server.h>
+//
+// // cli_rtsp_add_user lets user, and only user, play factory.
+// static void cli_rtsp_add_user(GstRTSPAuth *auth, GstRTSPMediaFactory *factory, const char *user, const char *password) {
+// 	GstRTSPToken *token = gst_rtsp_token_new(GST_RTSP_TOKEN_MEDIA_FACTORY_ROLE, G_TYPE_STRING, user, NULL);
+// 	gchar *basic = gst_rtsp_auth_make_basic(user, password);
+// 	gst_rtsp_auth_add_basic(auth, basic, token);
+// 	g_free(basic);
+// 	gst_rtsp_token_unref(token);
+// 	gst_rtsp_media_factory_add_role(factory, user,
+// 		GST_RTSP_PERM_MEDIA_FACTORY_ACCESS, G_TYPE_BOOLEAN, TRUE,
+// 		GST_RTSP_PERM_MEDIA_FACTORY_CONSTRUCT, G_TYPE_BOOLEAN, TRUE, NULL);
+// }
+import "C"
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"unsafe"
+)
+
+// serveRTSP mounts a media factory for launch and attaches the server to
+// the default main context. All clients share one pipeline, since a camera
+// can only be opened once.
+func serveRTSP(c *rtspServerConfig, launch string) error {
+	server := C.gst_rtsp_server_new()
+	defer C.g_object_unref(C.gpointer(unsafe.Pointer(server)))
+	address := C.CString(c.Address)
+	defer C.free(unsafe.Pointer(address))
+	C.gst_rtsp_server_set_address(server, address)
+	service := C.CString(strconv.Itoa(c.Port))
+	defer C.free(unsafe.Pointer(service))
+	C.gst_rtsp_server_set_service(server, service)
+
+	factory := C.gst_rtsp_media_factory_new()
+	cLaunch := C.CString(launch)
+	defer C.free(unsafe.Pointer(cLaunch))
+	C.gst_rtsp_media_factory_set_launch(factory, cLaunch)
+	C.gst_rtsp_media_factory_set_shared(factory, C.gboolean(1))
+	if c.TCPOnly {
+		C.gst_rtsp_media_factory_set_protocols(factory, C.GST_RTSP_LOWER_TRANS_TCP)
+	}
+	if c.User != "" {
+		auth := C.gst_rtsp_auth_new()
+		user, password := C.CString(c.User), C.CString(c.Password)
+		defer C.free(unsafe.Pointer(user))
+		defer C.free(unsafe.Pointer(password))
+		C.cli_rtsp_add_user(auth, factory, user, password)
+		C.gst_rtsp_server_set_auth(server, auth)
+		C.g_object_unref(C.gpointer(unsafe.Pointer(auth)))
+	}
+
+	mounts := C.gst_rtsp_server_get_mount_points(server)
+	mount := C.CString(c.Mount)
+	defer C.free(unsafe.Pointer(mount))
+	// The mount points take over the factory.
+	C.gst_rtsp_mount_points_add_factory(mounts, mount, factory)
+	C.g_object_unref(C.gpointer(unsafe.Pointer(mounts)))
+
+	// The attached source keeps its own reference to the server.
+	if C.gst_rtsp_server_attach(server, nil) == 0 {
+		return fmt.Errorf("cannot listen for RTSP on %s", net.JoinHostPort(c.Address, strconv.Itoa(c.Port)))
+	}
+	return nil
+}
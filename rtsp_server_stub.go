@@ -0,0 +1,10 @@
+//go:build !rtsp
+
+package main
+
+import "errors"
+
+// serveRTSP needs gst-rtsp-server, which is only linked with -tags rtsp.
+func serveRTSP(c *rtspServerConfig, launch string) error {
+	return errors.New("built without RTSP support; rebuild with -tags rtsp")
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// sdpStream is one sender whose payloader caps become an SDP media section.
+// Media ("video" or "audio") picks its payloader when a pipeline has more
+// than one.
+type sdpStream struct {
+	Pipeline *gst.Pipeline
+	Media    string
+	Host     string
+	Port     int
+	RTCPPort int
+	RTXPT    int
+	REDPT    int
+	FECPT    int
+	SRTP     *srtpConfig
+	TTL      int
+}
+
+// sdpReservedFields are caps fields that SDP carries outside of fmtp, or
+// that only describe this particular RTP session.
+var sdpReservedFields = map[string]bool{
+	"media":            true,
+	"payload":          true,
+	"clock-rate":       true,
+	"encoding-name":    true,
+	"encoding-params":  true,
+	"ssrc":             true,
+	"timestamp-offset": true,
+	"seqnum-offset":    true,
+	"clock-base":       true,
+	"seqnum-base":      true,
+}
+
+// publishSDP writes an SDP for streams to path and/or serves it over HTTP
+// on addr once every payloader has negotiated caps, and again whenever
+// they change (e.g. new sprop-parameter-sets).
+func publishSDP(streams []sdpStream, path, addr string) error {
+	var mu sync.Mutex
+	var current string
+	sessionID := time.Now().Unix()
+	origin := sdpOrigin(streams[0].Host, streams[0].Port)
+	// An SDP with an a=crypto line holds the SRTP key.
+	perm := os.FileMode(0o644)
+	for _, s := range streams {
+		if s.SRTP != nil {
+			perm = 0o600
+		}
+	}
+
+	var pads []*gst.Pad
+	update := func() {
+		media := make([]sdpMedia, 0, len(streams))
+		for _, s := range streams {
+			caps := payloaderCaps(pads, s.Media)
+			if caps == nil {
+				return
+			}
+			media = append(media, sdpMedia{Host: s.Host, Port: s.Port, RTCPPort: s.RTCPPort, RTXPT: s.RTXPT, REDPT: s.REDPT, FECPT: s.FECPT, SRTP: s.SRTP, TTL: s.TTL, Caps: caps})
+		}
+		sdp := buildSDP(sessionID, origin, media)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if sdp == current {
+			return
+		}
+		current = sdp
+		if path == "-" {
+			fmt.Print(sdp)
+		} else if path != "" {
+			if err := writeSDPFile(path, sdp, perm); err != nil {
+				fmt.Println("ERROR (sdp):", err)
+				return
+			}
+			fmt.Printf("Wrote SDP to %s\n", path)
+		}
+	}
+
+	seen := map[*gst.Pipeline]bool{}
+	for _, s := range streams {
+		if seen[s.Pipeline] {
+			continue
+		}
+		seen[s.Pipeline] = true
+		payloaders, err := findElementsBySuffix(s.Pipeline, "pay")
+		if err != nil {
+			return err
+		}
+		for _, payloader := range payloaders {
+			pads = append(pads, payloader.GetStaticPad("src"))
+		}
+	}
+	for _, pad := range pads {
+		if _, err := pad.Connect("notify::caps", update); err != nil {
+			return err
+		}
+	}
+
+	if addr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("sdp-http: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream.sdp", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sdp := current
+		mu.Unlock()
+		if sdp == "" {
+			http.Error(w, "stream not negotiated yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/sdp")
+		fmt.Fprint(w, sdp)
+	})
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			fmt.Println("ERROR (sdp-http):", err)
+		}
+	}()
+	fmt.Printf("Serving SDP on http://%s/stream.sdp\n", listener.Addr())
+	return nil
+}
+
+// writeSDPFile writes sdp to path with permissions perm, which also apply
+// when the file already exists, before anything is written to it.
+func writeSDPFile(path, sdp string, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteString(sdp); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// payloaderCaps returns the negotiated caps of the payloader pad for media,
+// or nil if it has none yet.
+func payloaderCaps(pads []*gst.Pad, media string) *gst.Structure {
+	for _, pad := range pads {
+		caps := pad.GetCurrentCaps()
+		if caps == nil || caps.GetSize() == 0 {
+			continue
+		}
+		if s := caps.GetStructureAt(0); fmt.Sprint(s.Values()["media"]) == media {
+			return s
+		}
+	}
+	return nil
+}
+
+// sdpMedia is the negotiated RTP caps of one stream and where it is sent.
+type sdpMedia struct {
+	Host     string
+	Port     int
+	RTCPPort int
+	// RTXPT, if set, is the payload type retransmissions are sent on.
+	RTXPT int
+	// REDPT and FECPT, if set, are the payload types of the RED packets
+	// the media and its ULPFEC packets are wrapped in.
+	REDPT int
+	FECPT int
+	// SRTP, if set, is given to receivers as an a=crypto attribute.
+	SRTP *srtpConfig
+	// TTL is the multicast TTL, for IPv4 multicast hosts.
+	TTL  int
+	Caps *gst.Structure
+}
+
+// buildSDP renders a session description with one media section per
+// stream, originating from the address origin.
+func buildSDP(sessionID int64, origin string, media []sdpMedia) string {
+	var sb strings.Builder
+	sb.WriteString("v=0\r\n")
+	fmt.Fprintf(&sb, "o=- %d 1 IN %s %s\r\n", sessionID, sdpAddrType(origin), origin)
+	sb.WriteString("s=cli\r\n")
+	sb.WriteString("t=0 0\r\n")
+	for _, m := range media {
+		values := m.Caps.Values()
+		pt := fmt.Sprint(values["payload"])
+		// RED, when used, is the payload type on the wire, so it goes
+		// first, and retransmissions repeat it.
+		pts := []string{pt}
+		outerPT := pt
+		if m.REDPT != 0 {
+			outerPT = fmt.Sprint(m.REDPT)
+			pts = []string{outerPT, pt, fmt.Sprint(m.FECPT)}
+		}
+		profile := "RTP/AVP"
+		if m.SRTP != nil {
+			profile = "RTP/SAVP"
+		}
+		if m.RTXPT != 0 {
+			profile += "F"
+			pts = append(pts, fmt.Sprint(m.RTXPT))
+		}
+		fmt.Fprintf(&sb, "m=%v %d %s %s\r\n", values["media"], m.Port, profile, strings.Join(pts, " "))
+		fmt.Fprintf(&sb, "c=IN %s %s\r\n", sdpAddrType(m.Host), sdpConnection(m.Host, m.TTL))
+		if m.RTCPPort != 0 && m.RTCPPort != m.Port+1 {
+			fmt.Fprintf(&sb, "a=rtcp:%d\r\n", m.RTCPPort)
+		}
+		if m.SRTP != nil {
+			fmt.Fprintf(&sb, "a=crypto:%s\r\n", m.SRTP.sdpCrypto())
+		}
+		rtpmap := fmt.Sprintf("%v/%v", values["encoding-name"], values["clock-rate"])
+		if params, ok := values["encoding-params"]; ok {
+			rtpmap += fmt.Sprintf("/%v", params)
+		}
+		fmt.Fprintf(&sb, "a=rtpmap:%s %s\r\n", pt, rtpmap)
+
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var fmtp []string
+		for _, k := range keys {
+			switch {
+			case sdpReservedFields[k], strings.HasPrefix(k, "x-"), strings.HasPrefix(k, "ssrc-"):
+			case strings.HasPrefix(k, "a-"):
+				fmt.Fprintf(&sb, "a=%s:%v\r\n", strings.TrimPrefix(k, "a-"), values[k])
+			default:
+				fmtp = append(fmtp, fmt.Sprintf("%s=%v", k, values[k]))
+			}
+		}
+		if len(fmtp) > 0 {
+			fmt.Fprintf(&sb, "a=fmtp:%s %s\r\n", pt, strings.Join(fmtp, ";"))
+		}
+		if m.REDPT != 0 {
+			fmt.Fprintf(&sb, "a=rtpmap:%d red/%v\r\n", m.REDPT, values["clock-rate"])
+			fmt.Fprintf(&sb, "a=fmtp:%d %s/%d\r\n", m.REDPT, pt, m.FECPT)
+			fmt.Fprintf(&sb, "a=rtpmap:%d ulpfec/%v\r\n", m.FECPT, values["clock-rate"])
+		}
+		if m.RTXPT != 0 {
+			fmt.Fprintf(&sb, "a=rtcp-fb:%s nack\r\n", pt)
+			fmt.Fprintf(&sb, "a=rtpmap:%d rtx/%v\r\n", m.RTXPT, values["clock-rate"])
+			fmt.Fprintf(&sb, "a=fmtp:%d apt=%s\r\n", m.RTXPT, outerPT)
+		}
+		sb.WriteString("a=sendonly\r\n")
+	}
+	return sb.String()
+}
+
+func sdpAddrType(host string) string {
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return "IP6"
+	}
+	return "IP4"
+}
+
+// sdpOrigin returns the local address streams to host leave from, for the
+// SDP's o= line. Connecting a UDP socket picks the route without sending.
+func sdpOrigin(host string, port int) string {
+	conn, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		if sdpAddrType(host) == "IP6" {
+			return "::1"
+		}
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
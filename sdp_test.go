@@ -0,0 +1,217 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+func TestBuildSDP(t *testing.T) {
+	gst.Init(nil)
+	caps := func(s string) *gst.Structure {
+		c := gst.NewCapsFromString(s)
+		if c == nil || c.GetSize() != 1 {
+			t.Fatalf("invalid caps %q", s)
+		}
+		return c.GetStructureAt(0)
+	}
+	h264 := caps(`application/x-rtp,media=(string)video,payload=(int)96,clock-rate=(int)90000,encoding-name=(string)H264,` +
+		`packetization-mode=(string)1,profile-level-id=(string)42c01f,sprop-parameter-sets=(string)"Z0LAH9kA,aMuDyyA=",` +
+		`a-framerate=(string)30,ssrc=(uint)1234,timestamp-offset=(uint)5,seqnum-offset=(uint)7,x-custom=(string)skip`)
+	opus := caps("application/x-rtp,media=(string)audio,payload=(int)96,clock-rate=(int)48000,encoding-name=(string)OPUS," +
+		"encoding-params=(string)2,sprop-maxcapturerate=(string)48000")
+	pcmu := caps("application/x-rtp,media=(string)audio,payload=(int)0,clock-rate=(int)8000,encoding-name=(string)PCMU")
+	vp8 := caps("application/x-rtp,media=(string)video,payload=(int)96,clock-rate=(int)90000,encoding-name=(string)VP8")
+	srtp128 := &srtpConfig{Key: keyBytes(30), Cipher: CipherAES128ICM, Auth: AuthHMACSHA1_80}
+	srtpGCM := &srtpConfig{Key: keyBytes(28), Cipher: CipherAES128GCM, Auth: AuthHMACSHA1_80}
+
+	tests := []struct {
+		name   string
+		origin string
+		media  []sdpMedia
+		want   []string
+	}{
+		{
+			name:   "video and audio",
+			origin: "192.168.1.10",
+			media: []sdpMedia{
+				{Host: "192.168.1.20", Port: 5000, RTCPPort: 5001, Caps: h264},
+				{Host: "192.168.1.20", Port: 5002, RTCPPort: 5003, Caps: opus},
+			},
+			want: []string{
+				"v=0",
+				"o=- 42 1 IN IP4 192.168.1.10",
+				"s=cli",
+				"t=0 0",
+				"m=video 5000 RTP/AVP 96",
+				"c=IN IP4 192.168.1.20",
+				"a=rtpmap:96 H264/90000",
+				"a=framerate:30",
+				"a=fmtp:96 packetization-mode=1;profile-level-id=42c01f;sprop-parameter-sets=Z0LAH9kA,aMuDyyA=",
+				"a=sendonly",
+				"m=audio 5002 RTP/AVP 96",
+				"c=IN IP4 192.168.1.20",
+				"a=rtpmap:96 OPUS/48000/2",
+				"a=fmtp:96 sprop-maxcapturerate=48000",
+				"a=sendonly",
+			},
+		},
+		{
+			name:   "RTCP port not after RTP",
+			origin: "192.168.1.10",
+			media:  []sdpMedia{{Host: "192.168.1.20", Port: 5001, RTCPPort: 5003, Caps: pcmu}},
+			want: []string{
+				"v=0",
+				"o=- 42 1 IN IP4 192.168.1.10",
+				"s=cli",
+				"t=0 0",
+				"m=audio 5001 RTP/AVP 0",
+				"c=IN IP4 192.168.1.20",
+				"a=rtcp:5003",
+				"a=rtpmap:0 PCMU/8000",
+				"a=sendonly",
+			},
+		},
+		{
+			name:   "RTX",
+			origin: "192.168.1.10",
+			media:  []sdpMedia{{Host: "192.168.1.20", Port: 5000, RTCPPort: 5001, RTXPT: 97, Caps: vp8}},
+			want: []string{
+				"v=0",
+				"o=- 42 1 IN IP4 192.168.1.10",
+				"s=cli",
+				"t=0 0",
+				"m=video 5000 RTP/AVPF 96 97",
+				"c=IN IP4 192.168.1.20",
+				"a=rtpmap:96 VP8/90000",
+				"a=rtcp-fb:96 nack",
+				"a=rtpmap:97 rtx/90000",
+				"a=fmtp:97 apt=96",
+				"a=sendonly",
+			},
+		},
+		{
+			name:   "RED and ULPFEC",
+			origin: "192.168.1.10",
+			media:  []sdpMedia{{Host: "192.168.1.20", Port: 5000, RTCPPort: 5001, REDPT: 98, FECPT: 99, Caps: vp8}},
+			want: []string{
+				"v=0",
+				"o=- 42 1 IN IP4 192.168.1.10",
+				"s=cli",
+				"t=0 0",
+				"m=video 5000 RTP/AVP 98 96 99",
+				"c=IN IP4 192.168.1.20",
+				"a=rtpmap:96 VP8/90000",
+				"a=rtpmap:98 red/90000",
+				"a=fmtp:98 96/99",
+				"a=rtpmap:99 ulpfec/90000",
+				"a=sendonly",
+			},
+		},
+		{
+			name:   "RTX of RED",
+			origin: "192.168.1.10",
+			media:  []sdpMedia{{Host: "192.168.1.20", Port: 5000, RTCPPort: 5001, RTXPT: 97, REDPT: 98, FECPT: 99, Caps: vp8}},
+			want: []string{
+				"v=0",
+				"o=- 42 1 IN IP4 192.168.1.10",
+				"s=cli",
+				"t=0 0",
+				"m=video 5000 RTP/AVPF 98 96 99 97",
+				"c=IN IP4 192.168.1.20",
+				"a=rtpmap:96 VP8/90000",
+				"a=rtpmap:98 red/90000",
+				"a=fmtp:98 96/99",
+				"a=rtpmap:99 ulpfec/90000",
+				"a=rtcp-fb:96 nack",
+				"a=rtpmap:97 rtx/90000",
+				"a=fmtp:97 apt=98",
+				"a=sendonly",
+			},
+		},
+		{
+			name:   "SRTP",
+			origin: "192.168.1.10",
+			media:  []sdpMedia{{Host: "192.168.1.20", Port: 5000, RTCPPort: 5001, SRTP: srtp128, Caps: vp8}},
+			want: []string{
+				"v=0",
+				"o=- 42 1 IN IP4 192.168.1.10",
+				"s=cli",
+				"t=0 0",
+				"m=video 5000 RTP/SAVP 96",
+				"c=IN IP4 192.168.1.20",
+				"a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwd",
+				"a=rtpmap:96 VP8/90000",
+				"a=sendonly",
+			},
+		},
+		{
+			name:   "SRTP with RTX",
+			origin: "192.168.1.10",
+			media:  []sdpMedia{{Host: "192.168.1.20", Port: 5000, RTCPPort: 5001, RTXPT: 97, SRTP: srtpGCM, Caps: vp8}},
+			want: []string{
+				"v=0",
+				"o=- 42 1 IN IP4 192.168.1.10",
+				"s=cli",
+				"t=0 0",
+				"m=video 5000 RTP/SAVPF 96 97",
+				"c=IN IP4 192.168.1.20",
+				"a=crypto:1 AEAD_AES_128_GCM inline:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGw==",
+				"a=rtpmap:96 VP8/90000",
+				"a=rtcp-fb:96 nack",
+				"a=rtpmap:97 rtx/90000",
+				"a=fmtp:97 apt=96",
+				"a=sendonly",
+			},
+		},
+		{
+			name:   "IPv4 multicast",
+			origin: "192.168.1.10",
+			media:  []sdpMedia{{Host: "239.1.1.1", Port: 5000, RTCPPort: 5001, TTL: 16, Caps: vp8}},
+			want: []string{
+				"v=0",
+				"o=- 42 1 IN IP4 192.168.1.10",
+				"s=cli",
+				"t=0 0",
+				"m=video 5000 RTP/AVP 96",
+				"c=IN IP4 239.1.1.1/16",
+				"a=rtpmap:96 VP8/90000",
+				"a=sendonly",
+			},
+		},
+		{
+			// IPv6 connection addresses carry no TTL.
+			name:   "IPv6 multicast",
+			origin: "fd00::10",
+			media:  []sdpMedia{{Host: "ff0e::1", Port: 5000, RTCPPort: 5001, TTL: 16, Caps: vp8}},
+			want: []string{
+				"v=0",
+				"o=- 42 1 IN IP6 fd00::10",
+				"s=cli",
+				"t=0 0",
+				"m=video 5000 RTP/AVP 96",
+				"c=IN IP6 ff0e::1",
+				"a=rtpmap:96 VP8/90000",
+				"a=sendonly",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := strings.Join(tt.want, "\r\n") + "\r\n"
+			if got := buildSDP(42, tt.origin, tt.media); got != want {
+				t.Errorf("buildSDP() =\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// keyBytes returns an n-byte SRTP key counting up from 0.
+func keyBytes(n int) []byte {
+	key := make([]byte, n)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// selftestMode is small enough for every encoder to keep up in real time.
+var selftestMode = Mode{Width: 640, Height: 480, Framerate: "30/1"}
+
+// latencyExpiry is how long a frame is waited for before it counts as lost
+// and stops being tracked for latency.
+const latencyExpiry = 2 * time.Second
+
+// loopbackStats collects what a sender and receiver pair saw. Latency is
+// matched by RTP timestamp: the sender records when each frame was
+// captured, the receiver maps its buffer timestamps back to RTP timestamps.
+type loopbackStats struct {
+	sent     atomic.Uint64
+	received atomic.Uint64
+	errors   atomic.Int64
+
+	mu         sync.Mutex
+	captured   map[uint32]gst.ClockTime
+	rtpForPTS  map[gst.ClockTime]uint32
+	latencySum time.Duration
+	latencyMax time.Duration
+	latencyN   int
+	err        error
+}
+
+// SelftestResult is one row of the selftest report.
+type SelftestResult struct {
+	Name     string
+	Encoder  string
+	Decoder  string
+	Sent     uint64
+	Received uint64
+	Errors   int64
+	Latency  time.Duration
+	Max      time.Duration
+	Skipped  string
+	Err      error
+}
+
+func runSelftest(mainLoop *glib.MainLoop, args []string) error {
+	fs := flag.NewFlagSet("cli selftest", flag.ContinueOnError)
+	seconds := fs.Int("duration", 5, "seconds to run each codec")
+	videoPort := fs.Int("video-port", 5000, "loopback UDP port for video")
+	audioPort := fs.Int("audio-port", 5001, "loopback UDP port for audio")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if *seconds <= 0 {
+		return errors.New("duration: must be a positive number")
+	}
+	if err := validatePort(*videoPort); err != nil {
+		return fmt.Errorf("video-port: %w", err)
+	}
+	if err := validatePort(*audioPort); err != nil {
+		return fmt.Errorf("audio-port: %w", err)
+	}
+	duration := time.Duration(*seconds) * time.Second
+
+	gst.Init(nil)
+
+	platform, err := detectPlatform()
+	if err != nil {
+		return err
+	}
+	linuxVariant := LinuxGeneric
+	if platform == "linux" {
+		linuxVariant = detectLinuxVariant()
+	}
+
+	var results []SelftestResult
+	for _, codec := range videoCodecs {
+		res := SelftestResult{
+			Name:    string(codec),
+			Encoder: probeEncoder(platform, linuxVariant, codec, LinuxH264VAAPI, LinuxH265VAAPI),
+			Decoder: probeDecoder(codec),
+		}
+		switch {
+		case res.Encoder == "":
+			res.Skipped = "no encoder"
+		case res.Decoder == "":
+			res.Skipped = "no decoder"
+		default:
+			fmt.Printf("Testing %s (%s -> %s) for %s...\n", codec, res.Encoder, res.Decoder, duration)
+			src := Source{Kind: SourceTest, Pattern: "smpte"}
+			sender := buildVideoPipeline(platform, linuxVariant, src, selftestMode, codec, LinuxH264VAAPI, res.Encoder).
+				UDPSink("127.0.0.1", *videoPort)
+			receiver := buildVideoReceiver(*videoPort, codec, res.Decoder, SinkCount, nil, nil)
+			runLoopback(mainLoop, sender, receiver, "videosink", duration, &res)
+		}
+		results = append(results, res)
+	}
+	for _, codec := range []AudioCodec{AudioOpus, AudioPCMU} {
+		res := SelftestResult{Name: string(codec), Encoder: "opusenc", Decoder: "opusdec"}
+		if codec == AudioPCMU {
+			res.Encoder, res.Decoder = "mulawenc", "mulawdec"
+		}
+		switch {
+		case gst.Find(res.Encoder) == nil:
+			res.Skipped = "no encoder"
+		case gst.Find(res.Decoder) == nil:
+			res.Skipped = "no decoder"
+		default:
+			fmt.Printf("Testing %s (%s -> %s) for %s...\n", codec, res.Encoder, res.Decoder, duration)
+			src := Source{Kind: SourceTest, Pattern: "sine"}
+			sender := buildAudioPipeline(platform, src, codec).UDPSink("127.0.0.1", *audioPort)
+			receiver := buildAudioReceiver(*audioPort, codec, SinkCount, nil, nil)
+			runLoopback(mainLoop, sender, receiver, "audiosink", duration, &res)
+		}
+		results = append(results, res)
+	}
+
+	printSelftestReport(results)
+	var failed []string
+	for _, res := range results {
+		if res.Skipped == "" && (res.Err != nil || res.Received == 0) {
+			failed = append(failed, res.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("selftest failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// runLoopback plays sender into receiver for duration and fills in res.
+func runLoopback(mainLoop *glib.MainLoop, senderDesc, receiverDesc *PipelineDesc, sinkName string, duration time.Duration, res *SelftestResult) {
+	stats := &loopbackStats{
+		captured:  make(map[uint32]gst.ClockTime),
+		rtpForPTS: make(map[gst.ClockTime]uint32),
+	}
+	sender, err := senderDesc.Build()
+	if err != nil {
+		res.Err = err
+		return
+	}
+	receiver, err := receiverDesc.Build()
+	if err != nil {
+		res.Err = err
+		return
+	}
+	// Both ends must share a clock for capture and arrival times to compare.
+	clock := gst.ObtainSystemClock().Clock
+	sender.ForceClock(clock)
+	receiver.ForceClock(clock)
+
+	if err := stats.attach(sender, receiver, sinkName, clock); err != nil {
+		res.Err = err
+		return
+	}
+	// Decoders post corrupt or undecodable frames as warnings and carry on,
+	// so those count as decode errors without failing the run.
+	decoder, err := findElementBySuffix(receiver, res.Decoder)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	decoderName := decoder.GetName()
+	for _, p := range []*gst.Pipeline{sender, receiver} {
+		p.GetPipelineBus().AddWatch(func(msg *gst.Message) bool {
+			switch msg.Type() {
+			case gst.MessageError:
+				stats.errors.Add(1)
+				stats.fail(fmt.Errorf("%s: %s", msg.Source(), msg.ParseError().Error()))
+				mainLoop.Quit()
+			case gst.MessageWarning:
+				if msg.Source() == decoderName {
+					stats.errors.Add(1)
+				}
+			}
+			return true
+		})
+	}
+	// The source is destroyed once its callback returns false, so it is only
+	// removed when the loop quit before the timeout.
+	timedOut := false
+	timeout, err := glib.TimeoutAdd(uint(duration/time.Millisecond), func() bool {
+		timedOut = true
+		mainLoop.Quit()
+		return false
+	})
+	if err != nil {
+		res.Err = err
+		return
+	}
+
+	receiver.SetState(gst.StatePlaying)
+	sender.SetState(gst.StatePlaying)
+	mainLoop.Run()
+
+	if !timedOut {
+		glib.SourceRemove(timeout)
+	}
+	if err := checkClockRate(sender, receiver); err != nil {
+		stats.fail(err)
+	}
+	for _, p := range []*gst.Pipeline{sender, receiver} {
+		p.BlockSetState(gst.StateNull)
+		p.GetPipelineBus().RemoveWatch()
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	res.Sent = stats.sent.Load()
+	res.Received = stats.received.Load()
+	res.Errors = stats.errors.Load()
+	res.Err = stats.err
+	if stats.latencyN > 0 {
+		res.Latency = stats.latencySum / time.Duration(stats.latencyN)
+		res.Max = stats.latencyMax
+	}
+}
+
+// attach adds the pad probes that count frames and measure latency: the
+// payloader's sink (frames sent) and source (capture time per RTP
+// timestamp), the depayloader's sink (RTP timestamp per receiver PTS) and
+// the receiver's sink (frames received).
+func (s *loopbackStats) attach(sender, receiver *gst.Pipeline, sinkName string, clock *gst.Clock) error {
+	payloader, err := findElementBySuffix(sender, "pay")
+	if err != nil {
+		return err
+	}
+	depayloader, err := findElementBySuffix(receiver, "depay")
+	if err != nil {
+		return err
+	}
+	sink, err := receiver.GetElementByName(sinkName)
+	if err != nil {
+		return err
+	}
+
+	payloader.GetStaticPad("sink").AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, _ *gst.PadProbeInfo) gst.PadProbeReturn {
+		s.sent.Add(1)
+		return gst.PadProbeOK
+	})
+	payloader.GetStaticPad("src").AddProbe(gst.PadProbeTypeBuffer|gst.PadProbeTypeBufferList, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		forEachBuffer(info, func(buf *gst.Buffer) {
+			if ts, ok := rtpTimestamp(buf.Bytes()); ok {
+				s.capture(ts, sender.GetBaseTime()+buf.PresentationTimestamp())
+			}
+		})
+		return gst.PadProbeOK
+	})
+	depayloader.GetStaticPad("sink").AddProbe(gst.PadProbeTypeBuffer|gst.PadProbeTypeBufferList, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		forEachBuffer(info, func(buf *gst.Buffer) {
+			if ts, ok := rtpTimestamp(buf.Bytes()); ok {
+				s.arrive(buf.PresentationTimestamp(), ts)
+			}
+		})
+		return gst.PadProbeOK
+	})
+	sink.GetStaticPad("sink").AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		s.received.Add(1)
+		if buf := info.GetBuffer(); buf != nil {
+			s.receive(buf.PresentationTimestamp(), clock.GetTime())
+		}
+		return gst.PadProbeOK
+	})
+	return nil
+}
+
+// checkClockRate fails if the sender's payloader timestamps at a different
+// clock rate than the receiver's caps declare, as a PCMU sender fed 48 kHz
+// audio would. Streams that never negotiated are left to the frame counts.
+func checkClockRate(sender, receiver *gst.Pipeline) error {
+	payloader, err := findElementBySuffix(sender, "pay")
+	if err != nil {
+		return err
+	}
+	depayloader, err := findElementBySuffix(receiver, "depay")
+	if err != nil {
+		return err
+	}
+	sent := padClockRate(payloader.GetStaticPad("src"))
+	expected := padClockRate(depayloader.GetStaticPad("sink"))
+	if sent != 0 && expected != 0 && sent != expected {
+		return fmt.Errorf("sender clock-rate %d, receiver expects %d", sent, expected)
+	}
+	return nil
+}
+
+// padClockRate is the clock-rate of the RTP caps negotiated on pad, or 0.
+func padClockRate(pad *gst.Pad) int {
+	caps := pad.GetCurrentCaps()
+	if caps == nil || caps.GetSize() == 0 {
+		return 0
+	}
+	rate, _ := getStructureValue(caps.GetStructureAt(0), "clock-rate").(int)
+	return rate
+}
+
+func (s *loopbackStats) capture(ts uint32, at gst.ClockTime) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.captured[ts]; !ok {
+		s.captured[ts] = at
+	}
+	for k, v := range s.captured {
+		if v+gst.ClockTime(latencyExpiry) < at {
+			delete(s.captured, k)
+		}
+	}
+}
+
+func (s *loopbackStats) arrive(pts gst.ClockTime, ts uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rtpForPTS[pts] = ts
+	for k := range s.rtpForPTS {
+		if k+gst.ClockTime(latencyExpiry) < pts {
+			delete(s.rtpForPTS, k)
+		}
+	}
+}
+
+func (s *loopbackStats) receive(pts, now gst.ClockTime) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts, ok := s.rtpForPTS[pts]
+	if !ok {
+		return
+	}
+	delete(s.rtpForPTS, pts)
+	at, ok := s.captured[ts]
+	if !ok || now < at {
+		return
+	}
+	delete(s.captured, ts)
+	latency := time.Duration(now - at)
+	s.latencySum += latency
+	s.latencyN++
+	if latency > s.latencyMax {
+		s.latencyMax = latency
+	}
+}
+
+func (s *loopbackStats) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func forEachBuffer(info *gst.PadProbeInfo, f func(*gst.Buffer)) {
+	if buf := info.GetBuffer(); buf != nil {
+		f(buf)
+		return
+	}
+	if list := info.GetBufferList(); list != nil {
+		list.ForEach(func(buf *gst.Buffer, _ uint) bool {
+			f(buf)
+			return true
+		})
+	}
+}
+
+// rtpTimestamp reads the timestamp from an RTP packet header.
+func rtpTimestamp(packet []byte) (uint32, bool) {
+	if len(packet) < 12 || packet[0]>>6 != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(packet[4:8]), true
+}
+
+func printSelftestReport(results []SelftestResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODEC\tENCODER\tDECODER\tSENT\tRECEIVED\tERRORS\tLATENCY (AVG/MAX)\tRESULT")
+	for _, res := range results {
+		if res.Skipped != "" {
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\t-\tskipped: %s\n", res.Name, orDash(res.Encoder), orDash(res.Decoder), res.Skipped)
+			continue
+		}
+		latency := "-"
+		if res.Latency > 0 {
+			latency = fmt.Sprintf("%s/%s", res.Latency.Round(time.Millisecond), res.Max.Round(time.Millisecond))
+		}
+		result := "ok"
+		switch {
+		case res.Err != nil:
+			result = "FAIL: " + res.Err.Error()
+		case res.Received == 0:
+			result = "FAIL: nothing received"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\n", res.Name, res.Encoder, res.Decoder, res.Sent, res.Received, res.Errors, latency, result)
+	}
+	w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/pbutils"
+)
+
+type SourceKind string
+
+const (
+	SourceDevice SourceKind = "device"
+	SourceTest   SourceKind = "test"
+	SourceFile   SourceKind = "file"
+)
+
+// Source is what a sender pipeline starts with: a capture device, a
+// synthetic test source or a media file.
+type Source struct {
+	Kind    SourceKind
+	Factory string
+	Device  Property
+	// Pattern is the videotestsrc pattern or audiotestsrc wave.
+	Pattern string
+	// TimeOverlay burns the running time into the test pattern.
+	TimeOverlay bool
+	// Location is the file a file source reads.
+	Location string
+	// Passthrough sends the file's encoded video without re-encoding it.
+	Passthrough bool
+}
+
+// FileInfo is what probeFile found in a media file.
+type FileInfo struct {
+	Codec     Codec
+	Width     int
+	Height    int
+	Framerate string
+	HasVideo  bool
+	HasAudio  bool
+}
+
+var videoTestPatterns = []string{"smpte", "ball", "snow", "zone-plate", "pinwheel", "gradient", "black"}
+
+var audioTestWaves = []string{"sine", "ticks", "white-noise", "pink-noise", "silence"}
+
+// deviceSource returns the Source for a monitored device, using fallback
+// when the device does not name its element factory.
+func deviceSource(device *gst.Device, fallback string) Source {
+	factoryName := fallback
+	if elem := device.CreateElement(""); elem != nil {
+		if factory := elem.GetFactory(); factory != nil && factory.GetName() != "" {
+			factoryName = factory.GetName()
+		}
+	}
+	return Source{Kind: SourceDevice, Factory: factoryName, Device: deviceProperty(device)}
+}
+
+// resolveVideoSource returns the video source chosen in opts, prompting for
+// whatever is missing and recording the answers in opts. It also returns the
+// device caps to pick a mode from and, for a file, what the file contains.
+func resolveVideoSource(reader *bufio.Reader, opts *Options, platform string) (Source, *gst.Caps, *FileInfo, error) {
+	if opts.VideoSource == "" && opts.File != "" {
+		opts.VideoSource = SourceFile
+	}
+	if opts.VideoSource == "" || opts.VideoSource == SourceDevice {
+		var extras []string
+		if opts.VideoSource == "" {
+			extras = []string{"Test pattern (videotestsrc)", "Video file (filesrc)"}
+		}
+		device, extra, err := selectDevice(reader, "Video/Source", "video/x-raw", "Select a camera", opts.VideoDevice, opts.VideoDeviceProperty, extras)
+		if err != nil {
+			return Source{}, nil, nil, err
+		}
+		if device != nil {
+			fallback := "avfvideosrc"
+			if platform == "linux" {
+				fallback = "v4l2src"
+			}
+			opts.VideoSource = SourceDevice
+			opts.VideoDevice = device.GetDisplayName()
+			opts.VideoDeviceProperty = buildDeviceProperty(device)
+			return deviceSource(device, fallback), device.GetCaps(), nil, nil
+		}
+		opts.VideoSource = []SourceKind{SourceTest, SourceFile}[extra]
+	}
+
+	var err error
+	if opts.VideoSource == SourceTest {
+		if opts.VideoPattern == "" {
+			opts.VideoPattern, err = promptTestPattern(reader, "Select a test pattern", videoTestPatterns)
+			if err != nil {
+				return Source{}, nil, nil, err
+			}
+			if !opts.TimeOverlay {
+				opts.TimeOverlay, err = promptYesNo(reader, "Overlay the running time", true)
+				if err != nil {
+					return Source{}, nil, nil, err
+				}
+			}
+		}
+		return Source{Kind: SourceTest, Pattern: opts.VideoPattern, TimeOverlay: opts.TimeOverlay}, nil, nil, nil
+	}
+
+	if opts.File == "" {
+		if opts.File, err = promptString(reader, "Video file", ""); err != nil {
+			return Source{}, nil, nil, err
+		}
+		if opts.File == "" {
+			return Source{}, nil, nil, errors.New("no video file given")
+		}
+		if !opts.Loop {
+			if opts.Loop, err = promptYesNo(reader, "Loop the file", true); err != nil {
+				return Source{}, nil, nil, err
+			}
+		}
+	}
+	file, err := probeFile(opts.File)
+	if err != nil {
+		return Source{}, nil, nil, err
+	}
+	if !file.HasVideo {
+		return Source{}, nil, nil, fmt.Errorf("%s has no video stream", opts.File)
+	}
+	src := Source{Kind: SourceFile, Location: opts.File}
+	if file.Codec == opts.Codec && (file.Codec == CodecH264 || file.Codec == CodecH265) {
+		src.Passthrough = true
+		fmt.Printf("%s is already %s; sending it without re-encoding.\n", opts.File, file.Codec)
+		return src, nil, file, nil
+	}
+	// Without flags the file keeps its own size and rate.
+	if opts.Width == 0 && file.Width > 0 {
+		opts.Width, opts.Height = file.Width, file.Height
+	}
+	if opts.Framerate == "" {
+		opts.Framerate = file.Framerate
+	}
+	return src, nil, file, nil
+}
+
+// resolveAudioSource is resolveVideoSource for audio. file is the probed
+// video file, if any, whose audio is offered as a choice.
+func resolveAudioSource(reader *bufio.Reader, opts *Options, platform string, file *FileInfo) (Source, error) {
+	if opts.AudioSource == "" || opts.AudioSource == SourceDevice {
+		var extras []string
+		kinds := []SourceKind{SourceTest}
+		if opts.AudioSource == "" {
+			extras = []string{"Test tone (audiotestsrc)"}
+			if file != nil && file.HasAudio {
+				extras = append(extras, "Audio from the video file")
+				kinds = append(kinds, SourceFile)
+			}
+		}
+		device, extra, err := selectDevice(reader, "Audio/Source", "audio/x-raw", "Select an audio device", opts.AudioDevice, opts.AudioDeviceProperty, extras)
+		if err != nil {
+			return Source{}, err
+		}
+		if device != nil {
+			fallback := "osxaudiosrc"
+			if platform == "linux" {
+				fallback = "pipewiresrc"
+			}
+			opts.AudioSource = SourceDevice
+			opts.AudioDevice = device.GetDisplayName()
+			opts.AudioDeviceProperty = buildDeviceProperty(device)
+			return deviceSource(device, fallback), nil
+		}
+		opts.AudioSource = kinds[extra]
+	}
+
+	if opts.AudioSource == SourceTest {
+		if opts.AudioWave == "" {
+			wave, err := promptTestPattern(reader, "Select a test wave", audioTestWaves)
+			if err != nil {
+				return Source{}, err
+			}
+			opts.AudioWave = wave
+		}
+		return Source{Kind: SourceTest, Pattern: opts.AudioWave}, nil
+	}
+
+	if opts.File == "" {
+		return Source{}, errors.New("audio-source file needs --file")
+	}
+	if file != nil && !file.HasAudio {
+		return Source{}, fmt.Errorf("%s has no audio stream", opts.File)
+	}
+	return Source{Kind: SourceFile, Location: opts.File}, nil
+}
+
+// addVideoSource appends src. props configure the capture element and are
+// dropped for test and file sources, which are instead fixed to mode. A
+// capture element in an MJPEG mode is followed by its decoder.
+func addVideoSource(p *PipelineDesc, src Source, mode Mode, props ...Property) *PipelineDesc {
+	switch src.Kind {
+	case SourceTest:
+		p.Add("videotestsrc", prop("is-live", true), optionalProp("pattern", src.Pattern)).
+			Caps(rawVideoCaps(mode, ""))
+		if src.TimeOverlay {
+			p.Add("timeoverlay")
+		}
+		return p
+	case SourceFile:
+		// clocksync paces the file to real time since udpsink does not sync.
+		return p.Add("filesrc", prop("location", src.Location)).
+			Add("decodebin").
+			Add("clocksync").
+			Add("videoconvert").
+			Add("videoscale").
+			Add("videorate").
+			Caps(rawVideoCaps(mode, ""))
+	default:
+		p.Add(src.Factory, props...)
+		if mode.Format == mjpegFormat {
+			// Decode MJPEG webcams here so the builders see raw video.
+			p.Caps(fmt.Sprintf("image/jpeg,width=%d,height=%d,framerate=%s", mode.Width, mode.Height, mode.Framerate)).
+				Add("jpegdec").
+				Add("videoconvert")
+		}
+		return p
+	}
+}
+
+// addAudioSource is addVideoSource for audio.
+func addAudioSource(p *PipelineDesc, src Source, props ...Property) *PipelineDesc {
+	switch src.Kind {
+	case SourceTest:
+		return p.Add("audiotestsrc", prop("is-live", true), optionalProp("wave", src.Pattern))
+	case SourceFile:
+		return p.Add("filesrc", prop("location", src.Location)).
+			Add("decodebin").
+			Add("clocksync").
+			Add("audioconvert").
+			Add("audioresample")
+	default:
+		return p.Add(src.Factory, props...)
+	}
+}
+
+// buildPassthroughVideoPipeline sends the already-encoded video of a file
+// source, which must be H264 or H265, without decoding it.
+func buildPassthroughVideoPipeline(src Source, codec Codec) *PipelineDesc {
+	p := &PipelineDesc{}
+	p.Add("filesrc", prop("location", src.Location)).
+		Add("parsebin")
+	switch codec {
+	case CodecH265:
+		p.Add("h265parse").
+			Add("clocksync").
+			Add("rtph265pay", prop("config-interval", -1), prop("aggregate-mode", "zero-latency"))
+	default:
+		p.Add("h264parse").
+			Add("clocksync").
+			Add("rtph264pay", prop("config-interval", -1), prop("aggregate-mode", "zero-latency"))
+	}
+	return p
+}
+
+// probeFile inspects a media file with the discoverer.
+func probeFile(path string) (*FileInfo, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	discoverer, err := pbutils.NewDiscoverer(gst.ClockTime(10 * time.Second))
+	if err != nil {
+		return nil, err
+	}
+	uri := (&url.URL{Scheme: "file", Path: abs}).String()
+	info, err := discoverer.DiscoverURI(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	fi := &FileInfo{HasAudio: len(info.GetAudioStreams()) > 0}
+	for _, v := range info.GetVideoStreams() {
+		if v.IsImage() {
+			continue
+		}
+		fi.HasVideo = true
+		fi.Width, fi.Height = int(v.GetWidth()), int(v.GetHeight())
+		if v.GetFramerateNum() > 0 && v.GetFramerateDenom() > 0 {
+			fi.Framerate = fmt.Sprintf("%d/%d", v.GetFramerateNum(), v.GetFramerateDenom())
+		}
+		if caps := v.GetCaps(); caps != nil && caps.GetSize() > 0 {
+			fi.Codec = codecForMedia(caps.GetStructureAt(0).Name())
+		}
+		break
+	}
+	return fi, nil
+}
+
+// codecForMedia maps an encoded video media type to its Codec, or "" if it
+// is not one the tool sends.
+func codecForMedia(media string) Codec {
+	switch media {
+	case "video/x-h264":
+		return CodecH264
+	case "video/x-h265":
+		return CodecH265
+	case "video/x-vp8":
+		return CodecVP8
+	case "video/x-vp9":
+		return CodecVP9
+	case "video/x-av1":
+		return CodecAV1
+	default:
+		return ""
+	}
+}
+
+// optionalProp is prop for a setting that is left at its default when empty.
+func optionalProp(name, value string) Property {
+	if value == "" {
+		return Property{}
+	}
+	return prop(name, value)
+}
+
+func parseSourceKind(val string) (SourceKind, error) {
+	for _, k := range []SourceKind{SourceDevice, SourceTest, SourceFile} {
+		if strings.EqualFold(val, string(k)) {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown source %q", val)
+}
+
+func promptTestPattern(reader *bufio.Reader, prompt string, patterns []string) (string, error) {
+	options := append(append([]string{}, patterns...), "Custom")
+	idx, err := promptChoice(reader, prompt, options)
+	if err != nil {
+		return "", err
+	}
+	if idx < len(patterns) {
+		return patterns[idx], nil
+	}
+	return promptString(reader, prompt, patterns[0])
+}
+
+func promptYesNo(reader *bufio.Reader, prompt string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		fmt.Printf("%s [%s]: ", prompt, hint)
+		line, err := readLine(reader)
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(line) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Println("Enter y or n.")
+	}
+}
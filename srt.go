@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+type SRTMode string
+
+const (
+	SRTCaller   SRTMode = "caller"
+	SRTListener SRTMode = "listener"
+)
+
+// srtPassphraseEnv is the environment variable the SRT passphrase is read
+// from when no --srt-passphrase is given.
+const srtPassphraseEnv = "CLI_SRT_PASSPHRASE"
+
+// srtConfig is one end of an SRT connection. A caller connects to Host; a
+// listener binds to it, or to every address if it is empty.
+type srtConfig struct {
+	Mode SRTMode
+	Host string
+	Port int
+	// Latency is in milliseconds; 0 keeps the SRT default of 120.
+	Latency    int
+	Passphrase string
+	// PBKeyLen is the AES key length in bytes, 0 for the default of 16.
+	PBKeyLen int
+	StreamID string
+}
+
+// props are the srtsink or srtsrc properties for c, with the passphrase
+// masked when printed.
+func (c *srtConfig) props() []Property {
+	props := []Property{
+		prop("uri", "srt://"+net.JoinHostPort(c.Host, strconv.Itoa(c.Port))),
+		prop("mode", c.Mode),
+	}
+	if c.Latency != 0 {
+		props = append(props, prop("latency", c.Latency))
+	}
+	if c.PBKeyLen != 0 {
+		props = append(props, prop("pbkeylen", c.PBKeyLen))
+	}
+	if c.Passphrase != "" {
+		props = append(props, secretProp("passphrase", c.Passphrase, "****"))
+	}
+	return append(props, optionalProp("streamid", c.StreamID))
+}
+
+// validateSRT checks the settings SRT would otherwise reject when it
+// connects.
+func validateSRT(latency int, passphrase string, pbKeyLen int) error {
+	if latency < 0 {
+		return errors.New("srt-latency: must be a positive number")
+	}
+	if passphrase != "" && (len(passphrase) < 10 || len(passphrase) > 79) {
+		return errors.New("srt-passphrase: must be 10 to 79 characters")
+	}
+	switch pbKeyLen {
+	case 0, 16, 24, 32:
+	default:
+		return fmt.Errorf("srt-pbkeylen: %d is not 16, 24 or 32", pbKeyLen)
+	}
+	if pbKeyLen != 0 && passphrase == "" {
+		return errors.New("srt-pbkeylen needs --srt-passphrase")
+	}
+	return nil
+}
+
+func parseSRTMode(val string) (SRTMode, error) {
+	for _, m := range []SRTMode{SRTCaller, SRTListener} {
+		if strings.EqualFold(val, string(m)) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown SRT mode %q", val)
+}
+
+func promptSRTMode(reader *bufio.Reader) (SRTMode, error) {
+	options := []string{
+		"caller (connect to a listener at the host)",
+		"listener (wait for a caller on the port)",
+	}
+	idx, err := promptChoice(reader, "Select an SRT mode", options)
+	if err != nil {
+		return SRTCaller, err
+	}
+	if idx == 1 {
+		return SRTListener, nil
+	}
+	return SRTCaller, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+type SRTPCipher string
+
+const (
+	CipherAES128ICM SRTPCipher = "aes-128-icm"
+	CipherAES256ICM SRTPCipher = "aes-256-icm"
+	CipherAES128GCM SRTPCipher = "aes-128-gcm"
+	CipherAES256GCM SRTPCipher = "aes-256-gcm"
+)
+
+var srtpCiphers = []SRTPCipher{CipherAES128ICM, CipherAES256ICM, CipherAES128GCM, CipherAES256GCM}
+
+type SRTPAuth string
+
+const (
+	AuthHMACSHA1_80 SRTPAuth = "hmac-sha1-80"
+	AuthHMACSHA1_32 SRTPAuth = "hmac-sha1-32"
+)
+
+// srtpKeyEnv is the environment variable an SRTP key is read from when no
+// --srtp-key is given.
+const srtpKeyEnv = "CLI_SRTP_KEY"
+
+// srtpConfig is the master key and salt, and the policy, that srtpenc and
+// srtpdec share for both RTP and RTCP.
+type srtpConfig struct {
+	Key    []byte
+	Cipher SRTPCipher
+	Auth   SRTPAuth
+}
+
+// loadSRTPConfig reads the key from key, $CLI_SRTP_KEY or file, in that
+// order, as hex. With rotate it instead writes a new random key to file, so
+// every start uses a fresh key that receivers pick up from the file.
+func loadSRTPConfig(key, file string, rotate bool, cipher SRTPCipher, auth SRTPAuth) (*srtpConfig, error) {
+	c := &srtpConfig{Cipher: cipher, Auth: auth}
+	if c.Cipher == "" {
+		c.Cipher = CipherAES128ICM
+	}
+	if c.Auth == "" {
+		c.Auth = AuthHMACSHA1_80
+	}
+	if rotate {
+		if file == "" {
+			return nil, errors.New("srtp-rotate needs --srtp-key-file to write the new key to")
+		}
+		c.Key = make([]byte, srtpKeyLen(c.Cipher))
+		if _, err := rand.Read(c.Key); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(file, []byte(hex.EncodeToString(c.Key)+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("srtp-key-file: %w", err)
+		}
+		fmt.Printf("Wrote a new SRTP key to %s\n", file)
+		return c, nil
+	}
+
+	key = flagOrEnv(key, srtpKeyEnv)
+	if key == "" && file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("srtp-key-file: %w", err)
+		}
+		key = string(data)
+	}
+	if key == "" {
+		return nil, fmt.Errorf("srtp needs a key from --srtp-key, $%s or --srtp-key-file", srtpKeyEnv)
+	}
+	var err error
+	if c.Key, err = hex.DecodeString(strings.TrimSpace(key)); err != nil {
+		return nil, errors.New("srtp key is not hex")
+	}
+	if len(c.Key) != srtpKeyLen(c.Cipher) {
+		return nil, fmt.Errorf("srtp key is %d bytes, %s needs %d (key and salt)", len(c.Key), c.Cipher, srtpKeyLen(c.Cipher))
+	}
+	return c, nil
+}
+
+// srtpKeyLen is the length of the master key plus salt for cipher.
+func srtpKeyLen(cipher SRTPCipher) int {
+	switch cipher {
+	case CipherAES256ICM:
+		return 46
+	case CipherAES128GCM:
+		return 28
+	case CipherAES256GCM:
+		return 44
+	default:
+		return 30
+	}
+}
+
+// auth is the authentication srtpenc and srtpdec use. GCM authenticates
+// the packets itself.
+func (c *srtpConfig) auth() string {
+	if c.Cipher == CipherAES128GCM || c.Cipher == CipherAES256GCM {
+		return "null"
+	}
+	return string(c.Auth)
+}
+
+// addSRTPEncoder adds an srtpenc named name for other chains to link
+// through its rtp_sink_0 and rtcp_sink_0 pads. The key is masked when
+// printed.
+func addSRTPEncoder(p *PipelineDesc, name string, c *srtpConfig) {
+	p.Branch().Add("srtpenc",
+		prop("name", name),
+		secretProp("key", hex.EncodeToString(c.Key), "****"),
+		prop("rtp-cipher", c.Cipher),
+		prop("rtp-auth", c.auth()),
+		prop("rtcp-cipher", c.Cipher),
+		prop("rtcp-auth", c.auth()))
+}
+
+// addSRTPDecoder adds an srtpdec named name for other chains to link
+// through its rtp_sink and rtcp_sink pads. srtpdec asks for the key of
+// every new SSRC through its request-key signal, which gst-launch has no
+// syntax for.
+func addSRTPDecoder(p *PipelineDesc, name string, c *srtpConfig) {
+	p.Branch().Add("srtpdec", prop("name", name)).
+		Setup(c.connectRequestKey)
+}
+
+// connectRequestKey answers srtpdec's request-key signal with c.
+func (c *srtpConfig) connectRequestKey(dec *gst.Element) error {
+	caps := fmt.Sprintf("application/x-srtp,srtp-key=(buffer)%s,srtp-cipher=%s,srtp-auth=%s,srtcp-cipher=%s,srtcp-auth=%s",
+		hex.EncodeToString(c.Key), c.Cipher, c.auth(), c.Cipher, c.auth())
+	if gst.NewCapsFromString(caps) == nil {
+		return errors.New("invalid SRTP key caps")
+	}
+	_, err := dec.Connect("request-key", func(_ *gst.Element, ssrc uint) *gst.Caps {
+		return gst.NewCapsFromString(caps)
+	})
+	return err
+}
+
+// srtpSend starts a branch from the pad reference src, through the srtpenc
+// named enc if there is one. kind is "rtp" or "rtcp".
+func srtpSend(p *PipelineDesc, src, enc, kind string) *PipelineDesc {
+	if enc == "" {
+		return p.Branch().Pad(src)
+	}
+	p.Branch().Pad(src).Pad(enc + "." + kind + "_sink_0")
+	return p.Branch().Pad(enc + "." + kind + "_src_0")
+}
+
+// srtpReceive adds chain c as a branch that ends at the pad reference
+// sink, through the srtpdec named dec if there is one.
+func srtpReceive(p, c *PipelineDesc, dec, kind, sink string) {
+	if dec == "" {
+		p.Branches = append(p.Branches, c.Pad(sink))
+		return
+	}
+	p.Branches = append(p.Branches, c.Pad(dec+"."+kind+"_sink"))
+	p.Branch().Pad(dec + "." + kind + "_src").Pad(sink)
+}
+
+// srtpCaps turns RTP caps into the SRTP caps srtpdec accepts.
+func srtpCaps(caps string) string {
+	return strings.Replace(caps, "application/x-rtp", "application/x-srtp", 1)
+}
+
+// sdpCrypto is the a=crypto attribute (RFC 4568) that gives receivers c.
+func (c *srtpConfig) sdpCrypto() string {
+	var suite string
+	switch c.Cipher {
+	case CipherAES128GCM:
+		suite = "AEAD_AES_128_GCM"
+	case CipherAES256GCM:
+		suite = "AEAD_AES_256_GCM"
+	case CipherAES256ICM:
+		suite = "AES_256_CM_HMAC_SHA1_" + strings.TrimPrefix(string(c.Auth), "hmac-sha1-")
+	default:
+		suite = "AES_CM_128_HMAC_SHA1_" + strings.TrimPrefix(string(c.Auth), "hmac-sha1-")
+	}
+	return fmt.Sprintf("1 %s inline:%s", suite, base64.StdEncoding.EncodeToString(c.Key))
+}
+
+func parseSRTPCipher(val string) (SRTPCipher, error) {
+	for _, c := range srtpCiphers {
+		if strings.EqualFold(val, string(c)) {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown SRTP cipher %q", val)
+}
+
+func parseSRTPAuth(val string) (SRTPAuth, error) {
+	for _, a := range []SRTPAuth{AuthHMACSHA1_80, AuthHMACSHA1_32} {
+		if strings.EqualFold(val, string(a)) {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("unknown SRTP auth %q", val)
+}
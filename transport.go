@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// Transport is how the encoded streams leave the sender.
+type Transport string
+
+const (
+	TransportRTP    Transport = "rtp"
+	TransportMPEGTS Transport = "mpegts"
+	TransportSRT    Transport = "srt"
+	TransportRTSP   Transport = "rtsp"
+	TransportWHIP   Transport = "whip"
+	TransportRTMP   Transport = "rtmp"
+)
+
+var transports = []Transport{TransportRTP, TransportMPEGTS, TransportSRT, TransportRTSP, TransportWHIP, TransportRTMP}
+
+func parseTransport(val string) (Transport, error) {
+	for _, t := range transports {
+		if strings.EqualFold(val, string(t)) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown transport %q", val)
+}
+
+func promptTransport(reader *bufio.Reader) (Transport, error) {
+	options := []string{
+		"RTP (a UDP stream per media, with RTCP)",
+		"MPEG-TS over UDP (one stream for VLC, QGroundControl, OBS)",
+		"MPEG-TS over SRT (encrypted, with retransmission, for the internet)",
+		"RTSP server (ground stations pull the stream on demand)",
+		"WHIP (WebRTC to a media server for browser viewers)",
+		"RTMP (H264 and AAC to a streaming ingest server)",
+	}
+	idx, err := promptChoice(reader, "Select a transport", options)
+	if err != nil {
+		return TransportRTP, err
+	}
+	return transports[idx], nil
+}
+
+// isMuxed reports whether transport muxes both streams into MPEG-TS.
+func isMuxed(transport Transport) bool {
+	return transport == TransportMPEGTS || transport == TransportSRT
+}
+
+// checkTransport fails if opts asks for something its transport cannot
+// carry.
+func checkTransport(opts *Options) error {
+	if opts.Transport == TransportRTMP {
+		if opts.Codec != CodecH264 {
+			return fmt.Errorf("%s cannot be sent over RTMP; use H264", opts.Codec)
+		}
+		if opts.AudioCodec != AudioAAC {
+			return fmt.Errorf("%s cannot be sent over RTMP; use AAC", opts.AudioCodec)
+		}
+	} else if opts.AudioCodec == AudioAAC {
+		return errors.New("AAC is only sent over the rtmp transport")
+	}
+	if opts.Transport == TransportRTP {
+		return nil
+	}
+	if isMuxed(opts.Transport) {
+		switch opts.Codec {
+		case CodecH264, CodecH265:
+		default:
+			return fmt.Errorf("%s cannot be muxed into MPEG-TS; use H264 or H265", opts.Codec)
+		}
+		if opts.AudioCodec != AudioOpus {
+			return fmt.Errorf("%s cannot be muxed into MPEG-TS; use OPUS", opts.AudioCodec)
+		}
+	}
+	if opts.Transport == TransportRTSP && opts.Loop {
+		// The media factory builds its own pipeline, whose end of stream
+		// ends every client's session.
+		return errors.New("--loop is not supported with the rtsp transport")
+	}
+	if opts.Transport == TransportWHIP && opts.Codec == CodecH265 {
+		return errors.New("H265 is not widely supported over WebRTC; use H264, VP8, VP9 or AV1")
+	}
+	for _, o := range []struct {
+		name string
+		set  bool
+	}{
+		{"sync", opts.Sync},
+		{"rtx", opts.RTX},
+		{"fec", opts.FEC},
+		{"srtp", opts.SRTP},
+		{"sdp", opts.SDPFile != "" || opts.SDPHTTP != ""},
+	} {
+		if o.set {
+			return fmt.Errorf("--%s needs the rtp transport", o.name)
+		}
+	}
+	if opts.Transport != TransportMPEGTS && isMulticast(opts.VideoHost) {
+		return fmt.Errorf("%s cannot use a multicast group as the host", opts.Transport)
+	}
+	return nil
+}
+
+// transportPrompts are the questions for the video host and port of
+// transport, and their defaults.
+func transportPrompts(transport Transport, mode SRTMode) (host, defHost, port string, defPort int) {
+	switch {
+	case transport == TransportRTSP:
+		return "Address to serve RTSP on", "0.0.0.0", "RTSP port", 8554
+	case transport == TransportSRT && mode == SRTListener:
+		return "Address to listen for SRT callers on", "0.0.0.0", "SRT port", 5000
+	case transport == TransportSRT:
+		return "SRT listener host", "127.0.0.1", "SRT port", 5000
+	case transport == TransportMPEGTS:
+		return "UDP host", "127.0.0.1", "UDP port", 5000
+	}
+	return "Video UDP host", "127.0.0.1", "Video UDP port", 5000
+}
+
+// trimPayloader drops the RTP payloader the pipeline builders end with,
+// leaving the encoded stream.
+func trimPayloader(p *PipelineDesc) *PipelineDesc {
+	if n := len(p.Elements); n > 0 && strings.HasSuffix(p.Elements[n-1].Factory, "pay") {
+		p.Elements = p.Elements[:n-1]
+	}
+	return p
+}
+
+// withParser appends parser unless the encoder chain already ends with it.
+func withParser(p *PipelineDesc, parser string) *PipelineDesc {
+	if n := len(p.Elements); n > 0 && p.Elements[n-1].Factory == parser {
+		return p
+	}
+	return p.Add(parser)
+}
+
+// buildMPEGTSPipeline muxes the video and audio chains, without their
+// payloaders, into MPEG-TS. The caller appends the sink. Seven TS packets
+// per buffer fill a UDP datagram without IP fragmentation.
+func buildMPEGTSPipeline(video, audio *PipelineDesc, codec Codec) *PipelineDesc {
+	p := &PipelineDesc{}
+	p.Add("mpegtsmux", prop("name", "mux"), prop("alignment", 7))
+	v := trimPayloader(video)
+	if codec == CodecH265 {
+		withParser(v, "h265parse")
+	} else {
+		withParser(v, "h264parse")
+	}
+	p.Branches = append(p.Branches, v.Pad("mux."), trimPayloader(audio).Pad("mux."))
+	return p
+}
+
+// runMuxedPipeline runs a sender that carries both streams in one
+// pipeline with no RTP session, such as MPEG-TS.
+func runMuxedPipeline(mainLoop *glib.MainLoop, desc *PipelineDesc, label string, loop, dryRun bool) error {
+	if dryRun {
+		fmt.Println(desc.LaunchCommand())
+		return nil
+	}
+	pipeline, err := desc.Build()
+	if err != nil {
+		return err
+	}
+	addPipelineWatch(pipeline, label, mainLoop, []*gst.Pipeline{pipeline}, loop)
+	pipeline.SetState(gst.StatePlaying)
+	return mainLoop.RunError()
+}
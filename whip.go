@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/gstsdp"
+	"github.com/go-gst/go-gst/gst/gstwebrtc"
+)
+
+// whipTokenEnv is the environment variable the WHIP bearer token is read
+// from when no --whip-token is given.
+const whipTokenEnv = "CLI_WHIP_TOKEN"
+
+// whipOpusPT is the payload type Opus is sent with over WebRTC. Both
+// payloaders default to 96, and a bundled session needs them to differ.
+const whipOpusPT = 111
+
+// whipHTTPClient sends the WHIP requests. An endpoint that stops answering
+// must not hang the sender, or its exit.
+var whipHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// whipClient publishes a webrtcbin to a WHIP endpoint (RFC 9725).
+type whipClient struct {
+	URL   string
+	Token string
+	// resource is the session URL the endpoint returned, which ends the
+	// session when deleted. It is set on the publishing goroutine.
+	mu       sync.Mutex
+	resource string
+}
+
+func validateWHIPURL(val string) error {
+	u, err := url.Parse(val)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", val)
+	}
+	return nil
+}
+
+// buildWHIPPipeline sends the video and audio chains through one webrtcbin
+// that c publishes.
+func buildWHIPPipeline(video, audio *PipelineDesc, audioCodec AudioCodec, c *whipClient) *PipelineDesc {
+	if audioCodec == AudioOpus {
+		last := &audio.Elements[len(audio.Elements)-1]
+		last.Props = append(last.Props, prop("pt", whipOpusPT))
+	}
+	p := &PipelineDesc{}
+	p.Add("webrtcbin", prop("name", "webrtc"), prop("bundle-policy", "max-bundle")).
+		Setup(c.connect)
+	p.Branches = append(p.Branches, video.Pad("webrtc.sink_0"), audio.Pad("webrtc.sink_1"))
+	return p
+}
+
+// connect publishes webrtc once it has both streams and needs an offer.
+// Failures are posted as pipeline errors.
+func (c *whipClient) connect(webrtc *gst.Element) error {
+	var once sync.Once
+	_, err := webrtc.Connect("on-negotiation-needed", func(webrtc *gst.Element) {
+		once.Do(func() {
+			// Negotiation waits on promises that webrtcbin resolves on its
+			// own thread, so it cannot block this signal.
+			go func() {
+				if err := c.publish(webrtc); err != nil {
+					webrtc.ErrorMessage(gst.DomainResource, gst.ResourceErrorFailed, "WHIP publishing failed", err.Error())
+				}
+			}()
+		})
+	})
+	return err
+}
+
+func (c *whipClient) publish(webrtc *gst.Element) error {
+	if err := setSendOnly(webrtc, 2); err != nil {
+		return err
+	}
+	offer, err := createDescription(webrtc, "create-offer", "offer")
+	if err != nil {
+		return err
+	}
+	sdp, err := setLocalDescription(webrtc, offer)
+	if err != nil {
+		return err
+	}
+	answer, err := c.post(sdp)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Publishing to %s\n", c.URL)
+	return setRemoteDescription(webrtc, gstwebrtc.SDP_TYPE_ANSWER, answer)
+}
+
+// post sends the offer and returns the endpoint's answer.
+func (c *whipClient) post(offer string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, c.URL, strings.NewReader(offer))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/sdp")
+	c.authorize(req)
+	resp, err := whipHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("%s answered %s: %s", c.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if loc, err := resp.Location(); err == nil {
+		c.mu.Lock()
+		c.resource = loc.String()
+		c.mu.Unlock()
+	}
+	return string(body), nil
+}
+
+// close ends the session on the endpoint, if it gave one.
+func (c *whipClient) close() {
+	c.mu.Lock()
+	resource := c.resource
+	c.mu.Unlock()
+	if resource == "" {
+		return
+	}
+	req, err := http.NewRequest(http.MethodDelete, resource, nil)
+	if err != nil {
+		return
+	}
+	c.authorize(req)
+	if resp, err := whipHTTPClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (c *whipClient) authorize(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+}
+
+// runWHIPClient publishes the video and audio chains until the pipeline
+// ends or the sender is interrupted, then closes the session.
+func runWHIPClient(mainLoop *glib.MainLoop, opts *Options, video, audio *PipelineDesc, loop bool) error {
+	c := &whipClient{URL: opts.WHIPURL, Token: flagOrEnv(opts.WHIPToken, whipTokenEnv)}
+	desc := buildWHIPPipeline(video, audio, opts.AudioCodec, c)
+	if opts.DryRun {
+		fmt.Println(desc.LaunchCommand())
+		fmt.Println("# the WHIP offer and answer go through webrtcbin's signals, which gst-launch-1.0 cannot express")
+		return nil
+	}
+	defer c.close()
+	pipeline, err := desc.Build()
+	if err != nil {
+		return err
+	}
+	addPipelineWatch(pipeline, "whip", mainLoop, []*gst.Pipeline{pipeline}, loop)
+	stop := stopOnSignal(mainLoop, pipeline)
+	defer stop()
+	pipeline.SetState(gst.StatePlaying)
+	err = mainLoop.RunError()
+	pipeline.BlockSetState(gst.StateNull)
+	return err
+}
+
+// stopOnSignal sends pipeline an EOS and quits mainLoop on SIGINT or
+// SIGTERM, which would otherwise kill the process before deferred cleanup
+// runs. The returned func stops listening.
+func stopOnSignal(mainLoop *glib.MainLoop, pipeline *gst.Pipeline) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-sigs; !ok {
+			return
+		}
+		pipeline.SendEvent(gst.NewEOSEvent())
+		mainLoop.Quit()
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}
+}
+
+// setSendOnly makes the first n transceivers of webrtc sendonly, as WHIP
+// expects. Requested sink pads get sendrecv ones.
+func setSendOnly(webrtc *gst.Element, n int) error {
+	for i := 0; i < n; i++ {
+		v, err := webrtc.Emit("get-transceiver", i)
+		if err != nil {
+			return err
+		}
+		tc, ok := v.(*gstwebrtc.RTPTransceiver)
+		if !ok || tc.Object == nil {
+			return fmt.Errorf("webrtcbin has no transceiver %d", i)
+		}
+		t, err := tc.GetPropertyType("direction")
+		if err != nil {
+			return err
+		}
+		direction, err := glib.ValueInit(t)
+		if err != nil {
+			return err
+		}
+		direction.SetEnum(int(gstwebrtc.RTP_TRANSCEIVER_DIRECTION_SENDONLY))
+		if err := tc.SetPropertyValue("direction", direction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createDescription emits signal, create-offer or create-answer, and
+// returns the description webrtcbin replies with under key.
+func createDescription(webrtc *gst.Element, signal, key string) (*gstwebrtc.SessionDescription, error) {
+	promise := gst.NewPromise()
+	if _, err := webrtc.Emit(signal, gst.NewStructure("options"), promise); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reply, err := promise.Await(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", signal, err)
+	}
+	v, err := reply.GetValue(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", signal, err)
+	}
+	desc, ok := v.(*gstwebrtc.SessionDescription)
+	if !ok {
+		return nil, fmt.Errorf("%s: no %s in %s", signal, key, reply)
+	}
+	return desc, nil
+}
+
+// setLocalDescription sets desc and returns it as SDP once ICE gathering
+// is complete. WHIP carries every candidate in the SDP rather than
+// trickling them.
+func setLocalDescription(webrtc *gst.Element, desc *gstwebrtc.SessionDescription) (string, error) {
+	if _, err := webrtc.Emit("set-local-description", desc, gst.NewPromise()); err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		state, err := webrtc.GetProperty("ice-gathering-state")
+		if err != nil {
+			return "", err
+		}
+		if s, _ := state.(int); s == int(gstwebrtc.ICE_GATHERING_STATE_COMPLETE) {
+			break
+		}
+		if time.Now().After(deadline) {
+			return "", errors.New("ICE gathering did not complete in 10s")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	v, err := webrtc.GetProperty("local-description")
+	if err != nil {
+		return "", err
+	}
+	local, ok := v.(*gstwebrtc.SessionDescription)
+	if !ok || local == nil {
+		return "", errors.New("webrtcbin has no local description")
+	}
+	return local.SDP().String(), nil
+}
+
+// setRemoteDescription sets sdp as the remote description of type typ and
+// waits for webrtcbin to accept it.
+func setRemoteDescription(webrtc *gst.Element, typ gstwebrtc.SDPType, sdp string) error {
+	msg, err := gstsdp.ParseSDPMessage(sdp)
+	if err != nil {
+		return fmt.Errorf("invalid SDP %s: %w", typ, err)
+	}
+	promise := gst.NewPromise()
+	if _, err := webrtc.Emit("set-remote-description", gstwebrtc.NewSessionDescription(typ, msg), promise); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// webrtcbin replies with nothing once the description is set, and with
+	// an error field when it rejects it.
+	reply, err := promise.Await(ctx)
+	if errors.Is(err, gst.ErrNilPromiseReply) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("set-remote-description: %w", err)
+	}
+	if e, ok := reply.Values()["error"]; ok {
+		return fmt.Errorf("set-remote-description: %v", e)
+	}
+	return nil
+}
+
+// buildWHIPReceiver plays what a WHIP publisher sends to the webrtcbin
+// serveWHIP answers for. As with rtpbin, each branch starts with the
+// depayloader for its codec, so webrtcbin's pads link to the right one.
+func buildWHIPReceiver(codec Codec, audioCodec AudioCodec, decoder string, sink ReceiveSink) *PipelineDesc {
+	p := &PipelineDesc{}
+	p.Add("webrtcbin", prop("name", "webrtc"), prop("bundle-policy", "max-bundle"), prop("latency", 50))
+	addVideoDecoder(p.Branch().Pad("webrtc."), codec, decoder, sink, true)
+	addAudioDecoder(p.Branch().Pad("webrtc."), audioCodec, sink, true)
+	return p
+}
+
+// serveWHIP is a minimal WHIP endpoint at http://addr/whip for testing
+// publishers without a media server. It takes one session, answered by
+// webrtc, and calls done when the publisher deletes it. An offer webrtc
+// rejects can be retried, but once webrtc holds an offer it cannot take
+// another, so a failure after that refuses every later offer.
+func serveWHIP(addr string, webrtc *gst.Element, done func()) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	var mu sync.Mutex
+	busy := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/whip", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST an SDP offer", http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		taken := busy
+		busy = true
+		mu.Unlock()
+		if taken {
+			http.Error(w, "a publisher is already connected", http.StatusConflict)
+			return
+		}
+		// A publisher whose offer is rejected can try again.
+		fail := func(err error) {
+			mu.Lock()
+			busy = false
+			mu.Unlock()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		offer, err := io.ReadAll(r.Body)
+		if err != nil {
+			fail(err)
+			return
+		}
+		// An offer that timed out may still have been set.
+		err = setRemoteDescription(webrtc, gstwebrtc.SDP_TYPE_OFFER, string(offer))
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			fail(err)
+			return
+		}
+		var answer string
+		if err == nil {
+			answer, err = answerWHIP(webrtc)
+		}
+		if err != nil {
+			fmt.Println("ERROR (whip):", err, "- restart the receiver to take another publisher")
+			http.Error(w, err.Error()+"; the endpoint takes no more offers", http.StatusInternalServerError)
+			return
+		}
+		fmt.Printf("WHIP publisher connected from %s\n", r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/sdp")
+		w.Header().Set("Location", "/whip/session")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, answer)
+	})
+	mux.HandleFunc("/whip/session", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "DELETE to end the session", http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Println("WHIP publisher disconnected")
+		done()
+	})
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			fmt.Println("ERROR (whip):", err)
+		}
+	}()
+	return nil
+}
+
+// answerWHIP creates and sets the answer to the offer webrtc holds, and
+// returns it as SDP.
+func answerWHIP(webrtc *gst.Element) (string, error) {
+	answer, err := createDescription(webrtc, "create-answer", "answer")
+	if err != nil {
+		return "", err
+	}
+	return setLocalDescription(webrtc, answer)
+}